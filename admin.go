@@ -0,0 +1,410 @@
+package main
+
+import (
+	. "clack/common"
+	"clack/storage"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"zombiezen.com/go/sqlite"
+)
+
+type adminCommand struct {
+	usage string
+	// Registers the command's flags, returning what to run once the database is open
+	setup func(flags *flag.FlagSet) func(db *sqlite.Conn) error
+}
+
+var adminCommands = map[string]adminCommand{
+	"user-create": {
+		usage: "create a user, optionally with a role",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			username := flags.String("username", "", "user name")
+			password := flags.String("password", "", "password, asked for when omitted")
+			role := flags.String("role", "", "role name or ID to give the user")
+			return func(db *sqlite.Conn) error {
+				return adminCreateUser(db, *username, *password, *role)
+			}
+		},
+	},
+	"user-password": {
+		usage: "reset a user's password and sign them out everywhere",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			username := flags.String("username", "", "user name")
+			password := flags.String("password", "", "new password, asked for when omitted")
+			return func(db *sqlite.Conn) error {
+				return adminResetPassword(db, *username, *password)
+			}
+		},
+	},
+	"role-add": {
+		usage: "give a user a role",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			username := flags.String("username", "", "user name")
+			role := flags.String("role", "", "role name or ID")
+			return func(db *sqlite.Conn) error {
+				return adminSetRole(db, *username, *role, true)
+			}
+		},
+	},
+	"role-remove": {
+		usage: "take a role from a user",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			username := flags.String("username", "", "user name")
+			role := flags.String("role", "", "role name or ID")
+			return func(db *sqlite.Conn) error {
+				return adminSetRole(db, *username, *role, false)
+			}
+		},
+	},
+	"tokens-revoke": {
+		usage: "sign a user out of every session",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			username := flags.String("username", "", "user name")
+			return func(db *sqlite.Conn) error {
+				return adminRevokeTokens(db, *username)
+			}
+		},
+	},
+	"checkpoint": {
+		usage: "move the write-ahead log into the database file",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			return func(db *sqlite.Conn) error {
+				return storage.NewTransaction(db).Checkpoint()
+			}
+		},
+	},
+	"vacuum": {
+		usage: "rebuild the database file to reclaim free space",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			return func(db *sqlite.Conn) error {
+				return storage.NewTransaction(db).Vacuum()
+			}
+		},
+	},
+	"verify": {
+		usage: "check foreign keys and that attachments match the files on disk",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			return adminVerify
+		},
+	},
+	"stats": {
+		usage: "print user, message and disk usage counts",
+		setup: func(flags *flag.FlagSet) func(db *sqlite.Conn) error {
+			return adminStats
+		},
+	},
+}
+
+// Flags for "clack admin <command>", anything after "--" is passed on to the config
+func parseAdminFlags(args []string) (func(db *sqlite.Conn) error, bool, []string, error) {
+	names := []string{}
+	for name := range adminCommands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Usage: clack admin <command> [flags] [-- config flags]")
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, adminCommands[name].usage)
+		}
+		return nil, false, nil, flag.ErrHelp
+	}
+
+	command, ok := adminCommands[args[0]]
+	if !ok {
+		return nil, false, nil, fmt.Errorf("unknown admin command '%s', expected one of %s", args[0], strings.Join(names, ", "))
+	}
+
+	flags := flag.NewFlagSet("clack admin "+args[0], flag.ContinueOnError)
+	run := command.setup(flags)
+	force := flags.Bool("force", false, "run even while a server is using the database")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return nil, false, nil, err
+	}
+
+	return run, *force, flags.Args(), nil
+}
+
+func runAdmin(run func(db *sqlite.Conn) error) error {
+	db, err := storage.OpenConnection(mainCtx)
+	if err != nil {
+		return err
+	}
+	defer storage.CloseConnection(db)
+
+	return run(db)
+}
+
+// Accepts either a role name (case insensitive) or its ID
+func findRole(tx *storage.Transaction, nameOrID string) (Role, error) {
+	roles, err := tx.GetAllRoles()
+	if err != nil {
+		return Role{}, err
+	}
+
+	for _, role := range roles {
+		if strings.EqualFold(role.Name, nameOrID) || strconv.FormatInt(int64(role.ID), 10) == nameOrID {
+			return role, nil
+		}
+	}
+
+	return Role{}, fmt.Errorf("role '%s' not found", nameOrID)
+}
+
+func adminCreateUser(db *sqlite.Conn, username string, password string, roleName string) error {
+	if username == "" || password == "" {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("-username and -password are required")
+		}
+		var err error
+		if username, password, err = promptCredentials("User", username, password); err != nil {
+			return err
+		}
+	}
+
+	// Clients hash the password before sending it
+	hash, err := HashPassword(HashSha256(password, ""))
+	if err != nil {
+		return err
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	userID, err := tx.AddUser(username, hash, "", "", "")
+	if err == nil {
+		err = tx.SetUserProfile(userID, username, "", "", ProfileColorDefault, AvatarModifiedDefault)
+	}
+
+	if err == nil && roleName != "" {
+		var role Role
+		if role, err = findRole(tx, roleName); err == nil {
+			err = tx.AddRoleToUser(userID, role.ID)
+		}
+	}
+
+	tx.Commit(err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created user '%s' (%d)\n", username, userID)
+	return nil
+}
+
+func adminResetPassword(db *sqlite.Conn, username string, password string) error {
+	if username == "" {
+		return fmt.Errorf("-username is required")
+	}
+	if password == "" {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("-password is required")
+		}
+		var err error
+		if _, password, err = promptCredentials("New", username, password); err != nil {
+			return err
+		}
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	userID, err := tx.GetUserIDByName(username)
+	if err == nil {
+		err = tx.SetUserPassword(userID, HashSha256(password, ""))
+	}
+	if err == nil {
+		err = tx.DeleteTokens(userID, "")
+	}
+
+	tx.Commit(err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Reset the password of '%s' and revoked their tokens\n", username)
+	return nil
+}
+
+func adminSetRole(db *sqlite.Conn, username string, roleName string, add bool) error {
+	if username == "" || roleName == "" {
+		return fmt.Errorf("-username and -role are required")
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	userID, err := tx.GetUserIDByName(username)
+
+	var role Role
+	if err == nil {
+		role, err = findRole(tx, roleName)
+	}
+
+	if err == nil {
+		if add {
+			err = tx.AddRoleToUser(userID, role.ID)
+		} else {
+			err = tx.DeleteRoleFromUser(userID, role.ID)
+		}
+	}
+
+	tx.Commit(err)
+	if err != nil {
+		return err
+	}
+
+	if add {
+		fmt.Printf("Gave '%s' the role '%s'\n", username, role.Name)
+	} else {
+		fmt.Printf("Took the role '%s' from '%s'\n", role.Name, username)
+	}
+	return nil
+}
+
+func adminRevokeTokens(db *sqlite.Conn, username string) error {
+	if username == "" {
+		return fmt.Errorf("-username is required")
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	userID, err := tx.GetUserIDByName(username)
+	if err == nil {
+		err = tx.DeleteTokens(userID, "")
+	}
+
+	tx.Commit(err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Revoked every token of '%s'\n", username)
+	return nil
+}
+
+func adminVerify(db *sqlite.Conn) error {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	violations, err := tx.CheckForeignKeys()
+	var attachments map[Snowflake]Snowflake
+	if err == nil {
+		attachments, err = tx.GetAttachmentMessages()
+	}
+	tx.Commit(err)
+	if err != nil {
+		return err
+	}
+
+	files, err := storage.ListAttachmentFiles()
+	if err != nil {
+		return err
+	}
+
+	problems := len(violations)
+	for _, violation := range violations {
+		fmt.Printf("Foreign key violation: %s\n", violation)
+	}
+
+	for attachmentID, messageID := range files {
+		if attachments[attachmentID] != messageID {
+			fmt.Printf("Orphaned file: %s\n", storage.GetAttachmentPath(messageID, attachmentID))
+			problems++
+		}
+	}
+
+	for attachmentID, messageID := range attachments {
+		if files[attachmentID] != messageID {
+			fmt.Printf("Missing file: %s\n", storage.GetAttachmentPath(messageID, attachmentID))
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problems", problems)
+	}
+
+	fmt.Printf("No problems found (%d attachments checked)\n", len(attachments))
+	return nil
+}
+
+func adminStats(db *sqlite.Conn) error {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	users, err := tx.GetUserCount()
+	var channels []Channel
+	if err == nil {
+		channels, err = tx.GetAllChannels()
+	}
+	var counts map[Snowflake]int
+	if err == nil {
+		counts, err = tx.GetChannelMessageCounts()
+	}
+	tx.Commit(err)
+	if err != nil {
+		return err
+	}
+
+	usage, err := storage.GetDiskUsage()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Users\t%d\n\n", users)
+
+	total := 0
+	fmt.Fprintln(w, "Channel\tMessages")
+	for _, channel := range channels {
+		if channel.Type == ChannelTypeCategory {
+			continue
+		}
+		name := channel.Name
+		if name == "" {
+			name = fmt.Sprintf("(%d)", channel.ID)
+		}
+		fmt.Fprintf(w, "%s\t%d\n", name, counts[channel.ID])
+		total += counts[channel.ID]
+	}
+	fmt.Fprintf(w, "Total\t%d\n\n", total)
+
+	folders := []string{}
+	for folder := range usage {
+		folders = append(folders, folder)
+	}
+	slices.Sort(folders)
+
+	var size int64
+	fmt.Fprintf(w, "Folder\tSize\n")
+	for _, folder := range folders {
+		name := folder + "/"
+		if folder == "" {
+			name = "(files)"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, formatSize(usage[folder]))
+		size += usage[folder]
+	}
+	fmt.Fprintf(w, "Total\t%s\n", formatSize(size))
+
+	return w.Flush()
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
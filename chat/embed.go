@@ -490,7 +490,7 @@ func GetEmbedFromURL(ctx context.Context, messageID Snowflake, targetURL string)
 		return nil, fmt.Errorf("failed to get content info: %w", err)
 	}
 
-	if info.Length > int64(MaxContentLength) {
+	if info.Length > Config.MaxContentLength {
 		return nil, fmt.Errorf("content too large: %d bytes", info.Length)
 	}
 
@@ -61,6 +61,65 @@ const (
 
 	EventTypeUserRoleAdd    = iota
 	EventTypeUserRoleDelete = iota
+
+	EventTypeChannelReorder = iota
+
+	EventTypeChannelOverwriteSet    = iota
+	EventTypeChannelOverwriteDelete = iota
+
+	EventTypeMessagePin             = iota
+	EventTypeMessageUnpin           = iota
+	EventTypePinnedMessagesRequest  = iota
+	EventTypePinnedMessagesResponse = iota
+
+	EventTypeSessionsRequest     = iota
+	EventTypeSessionsResponse    = iota
+	EventTypeSessionRevoke       = iota
+	EventTypeSessionRevokeOthers = iota
+
+	EventTypePasswordChange = iota
+
+	EventTypeInviteCodeAdd        = iota
+	EventTypeInviteCodeInvalidate = iota
+	EventTypeInviteCodesRequest   = iota
+	EventTypeInviteCodesResponse  = iota
+
+	EventTypeUserKick    = iota
+	EventTypeUserBan     = iota
+	EventTypeUserSilence = iota
+
+	EventTypeAuditLogRequest  = iota
+	EventTypeAuditLogResponse = iota
+
+	EventTypeSearchRequest  = iota
+	EventTypeSearchResponse = iota
+
+	EventTypeDMOpen         = iota
+	EventTypeDMOpenResponse = iota
+	EventTypeGroupDMAdd     = iota
+	EventTypeGroupDMLeave   = iota
+
+	EventTypeThreadCreate    = iota
+	EventTypeThreadsRequest  = iota
+	EventTypeThreadsResponse = iota
+
+	EventTypeMessageAck      = iota
+	EventTypeReadStateUpdate = iota
+
+	EventTypeResumed = iota
+
+	EventTypeGatewayStatsRequest  = iota
+	EventTypeGatewayStatsResponse = iota
+
+	EventTypeUserIdle = iota
+
+	EventTypeEmojiAdd    = iota
+	EventTypeEmojiUpdate = iota
+	EventTypeEmojiDelete = iota
+
+	EventTypeMessagePurge = iota
+
+	EventTypeSettingsUpdate = iota
 )
 
 type UnknownEvent struct {
@@ -70,9 +129,10 @@ type UnknownEvent struct {
 }
 
 type Event struct {
-	Type int         `json:"type"`
-	Seq  string      `json:"seq,omitempty"`
-	Data interface{} `json:"data"`
+	Type     int         `json:"type"`
+	Seq      string      `json:"seq,omitempty"`
+	Sequence int64       `json:"sequence,omitempty"`
+	Data     interface{} `json:"data"`
 }
 
 type ErrorResponse struct {
@@ -93,12 +153,28 @@ type SettingsResponse struct {
 	CaptchSiteKey      string `json:"captchaSiteKey,omitempty"`
 }
 
+type SettingsUpdateRequest struct {
+	SiteName           string `json:"siteName"`
+	LoginMessage       string `json:"loginMessage"`
+	DefaultPermissions int    `json:"defaultPermissions"`
+	UsesEmail          bool   `json:"usesEmail"`
+	UsesInviteCodes    bool   `json:"usesInviteCodes"`
+	UsesCaptcha        bool   `json:"usesCaptcha"`
+	UsesLoginCaptcha   bool   `json:"usesLoginCaptcha"`
+	CaptchaSiteKey     string `json:"captchaSiteKey"`
+	// Left unchanged when omitted, the secret is never sent back to clients
+	CaptchaSecretKey *string `json:"captchaSecretKey,omitempty"`
+}
+
 type OverviewResponse struct {
-	You      User             `json:"you"`
-	Users    []User           `json:"users"`
-	Channels []Channel        `json:"channels"`
-	Roles    []Role           `json:"roles"`
-	UserList UserListResponse `json:"userList"`
+	Session    string           `json:"session"`
+	You        User             `json:"you"`
+	Users      []User           `json:"users"`
+	Channels   []Channel        `json:"channels"`
+	ReadStates []ReadState      `json:"readStates"`
+	Roles      []Role           `json:"roles"`
+	Emojis     []Emoji          `json:"emojis"`
+	UserList   UserListResponse `json:"userList"`
 }
 
 type MessagesRequest struct {
@@ -179,6 +255,25 @@ type MessageDeleteEvent struct {
 	MessageID Snowflake `json:"message"`
 }
 
+type MessageDeleteBulkRequest struct {
+	ChannelID  Snowflake   `json:"channel" validate:"required"`
+	MessageIDs []Snowflake `json:"messages" validate:"required"`
+}
+
+// Deletes the newest Limit messages matching the filters, which are ignored when 0
+type MessagePurgeRequest struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+	AuthorID  Snowflake `json:"author,omitempty"`
+	After     int       `json:"after,omitempty"`
+	Before    int       `json:"before,omitempty"`
+	Limit     int       `json:"limit" validate:"required"`
+}
+
+type MessageDeleteBulkEvent struct {
+	ChannelID  Snowflake   `json:"channel"`
+	MessageIDs []Snowflake `json:"messages"`
+}
+
 type ReactionAddRequest struct {
 	MessageID Snowflake `json:"message"`
 	EmojiID   Snowflake `json:"emoji"`
@@ -192,6 +287,17 @@ type ReactionAddEvent struct {
 type ReactionDeleteRequest struct {
 	MessageID Snowflake `json:"message"`
 	EmojiID   Snowflake `json:"emoji"`
+	// Someone else's reaction, needs PermissionManageMessages
+	UserID Snowflake `json:"user,omitempty"`
+}
+
+type ReactionDeleteAllRequest struct {
+	MessageID Snowflake `json:"message"`
+}
+
+type ReactionDeleteEmojiRequest struct {
+	MessageID Snowflake `json:"message"`
+	EmojiID   Snowflake `json:"emoji"`
 }
 
 type ReactionDeleteEvent struct {
@@ -310,3 +416,262 @@ type UserRoleDeleteRequest struct {
 	UserID Snowflake `json:"user" validate:"required"`
 	RoleID Snowflake `json:"role" validate:"required"`
 }
+
+type ChannelAddRequest struct {
+	Name        string    `json:"name" validate:"required"`
+	Type        int       `json:"type" validate:"required"`
+	Description string    `json:"description,omitempty"`
+	Position    int       `json:"position" validate:"required"`
+	ParentID    Snowflake `json:"parent,omitempty"`
+}
+
+type ChannelUpdateRequest struct {
+	Channel Channel `json:"channel" validate:"required"`
+}
+
+type ChannelDeleteRequest struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+}
+
+type ChannelPosition struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+	Position  int       `json:"position" validate:"required"`
+}
+
+type ChannelReorderRequest struct {
+	Positions []ChannelPosition `json:"positions" validate:"required"`
+}
+
+type ChannelAddEvent struct {
+	Channel Channel `json:"channel"`
+}
+
+type ChannelUpdateEvent struct {
+	Channel Channel `json:"channel"`
+}
+
+type ChannelDeleteEvent struct {
+	ChannelID Snowflake `json:"channel"`
+}
+
+type ChannelOverwriteSetRequest struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+	Overwrite Overwrite `json:"overwrite" validate:"required"`
+}
+
+type ChannelOverwriteDeleteRequest struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+	Type      int       `json:"type" validate:"required"`
+	ID        Snowflake `json:"id" validate:"required"`
+}
+
+type MessagePinRequest struct {
+	MessageID Snowflake `json:"message" validate:"required"`
+}
+
+type MessageUnpinRequest struct {
+	MessageID Snowflake `json:"message" validate:"required"`
+}
+
+type PinnedMessagesRequest struct {
+	ChannelID Snowflake `json:"channel"`
+	Before    Snowflake `json:"before"`
+	Limit     int       `json:"limit"`
+}
+
+type PinnedMessagesResponse struct {
+	ChannelID Snowflake `json:"channel"`
+	Before    Snowflake `json:"before,omitempty"`
+	Limit     int       `json:"limit"`
+	Messages  []Message `json:"messages"`
+}
+
+type ChannelPinsUpdateEvent struct {
+	ChannelID Snowflake `json:"channel"`
+	MessageID Snowflake `json:"message"`
+	Pinned    bool      `json:"pinned"`
+}
+
+type UserTypingRequest struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+}
+
+type UserTypingEvent struct {
+	UserID    Snowflake `json:"user"`
+	ChannelID Snowflake `json:"channel"`
+	Typing    bool      `json:"typing"`
+}
+
+type SessionsRequest struct{}
+
+type SessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+type SessionRevokeRequest struct {
+	SessionID string `json:"session" validate:"required"`
+}
+
+type SessionRevokeOthersRequest struct{}
+
+type PasswordChangeRequest struct {
+	OldPassword string `json:"oldPassword" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required"`
+}
+
+type InviteCodeAddRequest struct {
+	ExpiresAt int `json:"expiresAt,omitempty"`
+	MaxUses   int `json:"maxUses,omitempty"`
+}
+
+type InviteCodeInvalidateRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type InviteCodesRequest struct{}
+
+type InviteCodesResponse struct {
+	Codes []InviteCode `json:"codes"`
+}
+
+type UserKickRequest struct {
+	UserID Snowflake `json:"user" validate:"required"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+type UserBanRequest struct {
+	UserID   Snowflake `json:"user" validate:"required"`
+	Reason   string    `json:"reason,omitempty"`
+	Duration int       `json:"duration,omitempty"` // Milliseconds, 0 is permanent
+	BanIP    bool      `json:"ip,omitempty"`
+}
+
+type UserSilenceRequest struct {
+	UserID   Snowflake `json:"user" validate:"required"`
+	Reason   string    `json:"reason,omitempty"`
+	Duration int       `json:"duration"` // Milliseconds, 0 lifts the silence
+}
+
+type AuditLogRequest struct {
+	Before   Snowflake `json:"before"`
+	Limit    int       `json:"limit"`
+	ActorID  Snowflake `json:"actor,omitempty"`
+	TargetID Snowflake `json:"target,omitempty"`
+	Action   *int      `json:"action,omitempty"`
+}
+
+type AuditLogResponse struct {
+	Before  Snowflake       `json:"before,omitempty"`
+	Limit   int             `json:"limit"`
+	Entries []AuditLogEntry `json:"entries"`
+}
+
+type SearchRequest struct {
+	Query  string    `json:"query" validate:"required"`
+	Before Snowflake `json:"before"`
+	Limit  int       `json:"limit"`
+}
+
+type SearchResponse struct {
+	Query      string    `json:"query"`
+	Before     Snowflake `json:"before,omitempty"`
+	Limit      int       `json:"limit"`
+	Messages   []Message `json:"messages"`
+	References []Message `json:"references,omitempty"`
+}
+
+type DMOpenRequest struct {
+	UserID Snowflake `json:"user" validate:"required"`
+}
+
+type DMOpenResponse struct {
+	Channel Channel `json:"channel"`
+}
+
+// Creates a group DM when no channel is given, otherwise adds to an existing one
+type GroupDMAddRequest struct {
+	ChannelID Snowflake   `json:"channel,omitempty"`
+	UserIDs   []Snowflake `json:"users" validate:"required"`
+	Name      string      `json:"name,omitempty"`
+}
+
+type GroupDMLeaveRequest struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+}
+
+type ThreadCreateRequest struct {
+	MessageID Snowflake `json:"message" validate:"required"`
+	Name      string    `json:"name" validate:"required"`
+}
+
+type ThreadsRequest struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+	Archived  bool      `json:"archived,omitempty"`
+	Before    int       `json:"before,omitempty"` // Last activity, only used for archived threads
+	Limit     int       `json:"limit"`
+}
+
+type ThreadsResponse struct {
+	ChannelID Snowflake `json:"channel"`
+	Archived  bool      `json:"archived,omitempty"`
+	Before    int       `json:"before,omitempty"`
+	Limit     int       `json:"limit"`
+	Threads   []Channel `json:"threads"`
+}
+
+type MessageAckRequest struct {
+	ChannelID Snowflake `json:"channel" validate:"required"`
+	MessageID Snowflake `json:"message" validate:"required"`
+}
+
+type ReadStateUpdateEvent struct {
+	ReadState ReadState `json:"readState"`
+}
+
+type ResumedEvent struct {
+	Session  string `json:"session"`
+	Sequence int64  `json:"sequence"`
+	Replayed int    `json:"replayed"`
+}
+
+type GatewayStatsRequest struct{}
+
+type GatewayStatsResponse struct {
+	Connections int   `json:"connections"`
+	Detached    int   `json:"detached"`
+	Dropped     int64 `json:"dropped"`
+	Coalesced   int64 `json:"coalesced"`
+	Overflows   int64 `json:"overflows"`
+}
+
+type UserIdleRequest struct {
+	IdleSince int `json:"idleSince"`
+}
+
+type EmojiAddRequest struct {
+	Name string `json:"name" validate:"required"`
+
+	// Internal
+	ID Snowflake `json:"-"`
+}
+
+type EmojiUpdateRequest struct {
+	EmojiID Snowflake `json:"emoji" validate:"required"`
+	Name    string    `json:"name" validate:"required"`
+}
+
+type EmojiDeleteRequest struct {
+	EmojiID Snowflake `json:"emoji" validate:"required"`
+}
+
+type EmojiAddEvent struct {
+	Emoji Emoji `json:"emoji"`
+}
+
+type EmojiUpdateEvent struct {
+	Emoji Emoji `json:"emoji"`
+}
+
+type EmojiDeleteEvent struct {
+	EmojiID Snowflake `json:"emoji"`
+}
@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"bytes"
 	"clack/common"
 	. "clack/common"
 	"clack/storage"
@@ -13,6 +14,7 @@ import (
 	"mime/multipart"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -21,6 +23,12 @@ import (
 
 const IndexRebuildThrottle = time.Second
 const IndexPushThrottle = time.Millisecond * 250
+const TypingExpiryInterval = time.Second
+
+// Outgoing budget per connection, a client that falls this far behind is disconnected and has to resume
+const QueueCountBudget = 512
+const QueueByteBudget = 16 * 1024 * 1024
+const OverflowCloseTimeout = time.Second * 5
 
 var gwLog = NewLogger("GATEWAY")
 
@@ -39,10 +47,27 @@ type Gateway struct {
 	connections      map[string]*GatewayConnection
 	connectionsMutex sync.RWMutex
 
+	// Attached connections of each user, guarded by connectionsMutex
+	userConnections map[Snowflake]map[string]*GatewayConnection
+	// Connections that haven't authenticated yet, guarded by connectionsMutex
+	guests map[*GatewayConnection]struct{}
+
 	pending      map[Snowflake]*PendingRequest
 	pendingMutex sync.RWMutex
 
-	index Index
+	index  Index
+	typing TypingIndex
+
+	stats GatewayStats
+}
+
+type GatewayStats struct {
+	// Events that were buffered for resuming but never written to the socket
+	Dropped atomic.Int64
+	// User list updates merged into a later one
+	Coalesced atomic.Int64
+	// Connections closed for exceeding their queue budget
+	Overflows atomic.Int64
 }
 
 func (gw *Gateway) GetIndex() *Index {
@@ -51,16 +76,49 @@ func (gw *Gateway) GetIndex() *Index {
 
 func (gw *Gateway) AddConnection(conn *GatewayConnection) {
 	gw.connectionsMutex.Lock()
+	delete(gw.guests, conn)
 	gw.connections[conn.session] = conn
+	gw.attachConnection(conn)
 	gw.connectionsMutex.Unlock()
 }
 
 func (gw *Gateway) RemoveConnection(conn *GatewayConnection) {
 	gw.connectionsMutex.Lock()
 	delete(gw.connections, conn.session)
+	gw.detachConnection(conn)
+	gw.connectionsMutex.Unlock()
+}
+
+func (gw *Gateway) AddGuest(conn *GatewayConnection) {
+	gw.connectionsMutex.Lock()
+	gw.guests[conn] = struct{}{}
 	gw.connectionsMutex.Unlock()
 }
 
+func (gw *Gateway) RemoveGuest(conn *GatewayConnection) {
+	gw.connectionsMutex.Lock()
+	delete(gw.guests, conn)
+	gw.connectionsMutex.Unlock()
+}
+
+func (gw *Gateway) attachConnection(conn *GatewayConnection) {
+	if gw.userConnections[conn.userID] == nil {
+		gw.userConnections[conn.userID] = make(map[string]*GatewayConnection)
+	}
+	gw.userConnections[conn.userID][conn.session] = conn
+}
+
+func (gw *Gateway) detachConnection(conn *GatewayConnection) {
+	conns := gw.userConnections[conn.userID]
+	if conns[conn.session] != conn {
+		return
+	}
+	delete(conns, conn.session)
+	if len(conns) == 0 {
+		delete(gw.userConnections, conn.userID)
+	}
+}
+
 func (gw *Gateway) GetConnection(session string) *GatewayConnection {
 	gw.connectionsMutex.RLock()
 	defer gw.connectionsMutex.RUnlock()
@@ -70,6 +128,69 @@ func (gw *Gateway) GetConnection(session string) *GatewayConnection {
 	return nil
 }
 
+// Closes every connection authenticated with the given token
+func (gw *Gateway) CloseConnectionsByToken(token string, code int) {
+	gw.closeConnections(func(conn *GatewayConnection) bool {
+		return conn.token == token
+	}, code)
+}
+
+// Closes every connection of a user, except those authenticated with the given token (which may be empty)
+func (gw *Gateway) CloseConnectionsByUser(userID Snowflake, except string, code int) {
+	gw.closeConnections(func(conn *GatewayConnection) bool {
+		return conn.userID == userID && (except == "" || conn.token != except)
+	}, code)
+}
+
+// Closing writes to the socket, so it happens after the lock is released
+func (gw *Gateway) closeConnections(match func(conn *GatewayConnection) bool, code int) {
+	gw.connectionsMutex.RLock()
+	matched := []*GatewayConnection{}
+	for _, conn := range gw.connections {
+		if match(conn) {
+			matched = append(matched, conn)
+		}
+	}
+	gw.connectionsMutex.RUnlock()
+
+	for _, conn := range matched {
+		conn.CloseWithError(code)
+	}
+}
+
+func (gw *Gateway) GetClientIPsByUser(userID Snowflake) []string {
+	gw.connectionsMutex.RLock()
+	defer gw.connectionsMutex.RUnlock()
+	ips := []string{}
+	for _, conn := range gw.connections {
+		if conn.userID == userID {
+			if ip := conn.ClientIP(); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+func (gw *Gateway) GetStats() GatewayStatsResponse {
+	gw.connectionsMutex.RLock()
+	defer gw.connectionsMutex.RUnlock()
+
+	stats := GatewayStatsResponse{
+		Dropped:   gw.stats.Dropped.Load(),
+		Coalesced: gw.stats.Coalesced.Load(),
+		Overflows: gw.stats.Overflows.Load(),
+	}
+	for _, conn := range gw.connections {
+		if !conn.Detached() {
+			stats.Connections++
+		} else {
+			stats.Detached++
+		}
+	}
+	return stats
+}
+
 func (gw *Gateway) PushPendingRequest(req *PendingRequest, id Snowflake) {
 	gw.pendingMutex.Lock()
 	gw.pending[id] = req
@@ -90,7 +211,16 @@ type GatewayConnection struct {
 	ws  *websocket.Conn
 	ctx context.Context
 
-	queue chan *Event
+	queue       chan []byte
+	queuedBytes int
+	done        chan struct{}
+
+	// Set until the settings, overview or resume replay are queued, guarded by queueMutex
+	introducing bool
+	held        []Event
+
+	// Set when the user list changed, the writer sends the latest slice once
+	userListNotify chan struct{}
 
 	userID  Snowflake
 	token   string
@@ -99,37 +229,158 @@ type GatewayConnection struct {
 	seq     string
 	request int
 
-	closing bool
+	// Shared between the reader, the writer and relays, so these are atomic
+	closing atomic.Bool
+	// Reported by the client, 0 while it's in use
+	idleSince atomic.Int64
+
+	// Resume state, a detached connection stays in the gateway until it's resumed or expires
+	buffer *EventBuffer
+	// Unix nanoseconds, 0 while attached
+	detachedAt atomic.Int64
+	terminated atomic.Bool
 
 	lastUserListRange IndexRange
 
+	queueMutex sync.Mutex
 	writeMutex sync.Mutex
 }
 
-func (c *GatewayConnection) writeEvent(msg Event) {
+func encodeEvent(msg Event) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GatewayConnection) writeData(data []byte) {
 	c.writeMutex.Lock()
 	defer c.writeMutex.Unlock()
 
-	writer, err := c.ws.NextWriter(websocket.TextMessage)
+	if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+		gwLog.Printf("Failed to write: %v", err)
+	}
+}
+
+// Writes straight to the socket, skipping the queue, only used when closing
+func (c *GatewayConnection) writeEvent(msg Event) {
+	data, err := encodeEvent(msg)
 	if err != nil {
-		gwLog.Printf("Failed to get writer: %v", err)
+		gwLog.Printf("Failed to encode event: %v", err)
 		return
 	}
+	c.writeData(data)
+}
 
-	encoder := json.NewEncoder(writer)
-	encoder.SetEscapeHTML(false)
-	encoder.Encode(msg)
+// Everything sent to the client goes through here, in order, stamped with the next sequence number
+func (c *GatewayConnection) send(msg Event, stamp bool) {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+	c.sendLocked(msg, stamp)
+}
+
+func (c *GatewayConnection) sendLocked(msg Event, stamp bool) {
+	if c.terminated.Load() {
+		return
+	}
+
+	if stamp {
+		msg = c.buffer.Push(msg)
+	}
+
+	if !c.Connected() {
+		if !c.Detached() {
+			gw.stats.Dropped.Add(1)
+		}
+		return
+	}
+
+	data, err := encodeEvent(msg)
+	if err != nil {
+		gwLog.Printf("Failed to encode event: %v", err)
+		return
+	}
 
-	writer.Close()
+	if len(c.queue) >= cap(c.queue) || c.queuedBytes+len(data) > QueueByteBudget {
+		gwLog.Printf("Queue budget exceeded, disconnecting %s", c.ClientIP())
+		gw.stats.Overflows.Add(1)
+		gw.stats.Dropped.Add(1)
+		c.closing.Store(true)
+		go c.Overflow()
+		return
+	}
+
+	c.queuedBytes += len(data)
+	c.queue <- data
+}
+
+// Events pushed by the server wait until the introduction is written, so they can't overtake it
+func (c *GatewayConnection) sendOrHold(msg Event) {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+
+	if c.introducing {
+		c.held = append(c.held, msg)
+		return
+	}
+	c.sendLocked(msg, true)
+}
+
+// Sends whatever was held back during the introduction, nothing can slip in between
+func (c *GatewayConnection) FinishIntroduction() {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+
+	c.introducing = false
+	for _, msg := range c.held {
+		c.sendLocked(msg, true)
+	}
+	c.held = nil
 }
 
 func (c *GatewayConnection) Write(msg Event) {
 	msg.Seq = c.seq
-	c.writeEvent(msg)
+	c.send(msg, true)
 }
 
 func (c *GatewayConnection) WriteUnsolicited(msg Event) {
-	c.writeEvent(msg)
+	c.sendOrHold(msg)
+}
+
+// Queues an event that already has its sequence number, or shouldn't have one
+func (c *GatewayConnection) WriteStamped(msg Event) {
+	c.send(msg, false)
+}
+
+// Marks the user list as changed, repeated changes before the writer gets to it are merged
+func (c *GatewayConnection) NotifyUserList() {
+	select {
+	case c.userListNotify <- struct{}{}:
+	default:
+		gw.stats.Coalesced.Add(1)
+	}
+}
+
+// Disconnects a client that can't keep up, unlike CloseWithError the session stays resumable
+func (c *GatewayConnection) Overflow() {
+	// The writer may be stuck on the slow socket, don't wait on it forever
+	if conn := c.ws.UnderlyingConn(); conn != nil {
+		conn.SetWriteDeadline(time.Now().Add(OverflowCloseTimeout))
+	}
+
+	c.writeEvent(Event{
+		Type: EventTypeErrorResponse,
+		Data: ErrorResponse{
+			Code: ErrorCodeConnectionClosing,
+		},
+	})
+
+	if err := c.Close(); err != nil {
+		gwLog.Printf("Failed to close connection: %v", err)
+	}
 }
 
 func (c *GatewayConnection) Read() (*UnknownEvent, error) {
@@ -141,6 +392,7 @@ func (c *GatewayConnection) Read() (*UnknownEvent, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get reader: %v", err)
 	}
+	c.Heartbeat()
 
 	decoder := json.NewDecoder(reader)
 	var msg UnknownEvent
@@ -172,18 +424,39 @@ func (c *GatewayConnection) Close() error {
 	return nil
 }
 
-func (c *GatewayConnection) Relay(event *Event) {
-	if len(c.queue) >= cap(c.queue) {
-		gwLog.Printf("Queue is full, dropping event: %v", event)
-		// Close the connection?
+// Tells the client why it's being disconnected, then closes the connection
+func (c *GatewayConnection) CloseWithError(code int) {
+	c.terminated.Store(true)
+	if !c.Connected() {
 		return
 	}
 
-	if !c.Connected() || !c.Authenticated() {
+	// Don't wait forever behind a writer stuck on a slow socket
+	if conn := c.ws.UnderlyingConn(); conn != nil {
+		conn.SetWriteDeadline(time.Now().Add(OverflowCloseTimeout))
+	}
+
+	c.writeEvent(Event{
+		Type: EventTypeErrorResponse,
+		Data: ErrorResponse{
+			Code:    code,
+			Request: c.request,
+		},
+	})
+
+	if err := c.Close(); err != nil {
+		gwLog.Printf("Failed to close connection: %v", err)
+	}
+	c.closing.Store(true)
+}
+
+func (c *GatewayConnection) Relay(event *Event) {
+	if !c.Authenticated() {
 		return
 	}
 
-	c.queue <- event
+	// Buffered even when detached, the client can resume to get it
+	c.sendOrHold(*event)
 }
 
 func (c *GatewayConnection) ClientIP() string {
@@ -204,7 +477,7 @@ func (c *GatewayConnection) ClientIP() string {
 func (c *GatewayConnection) TryAuthenticate(token string, db *sqlite.Conn) bool {
 	tx := storage.NewTransaction(db)
 	tx.Start()
-	userID, err := tx.Authenticate(token)
+	userID, err := tx.Authenticate(token, c.ClientIP())
 	tx.Commit(err)
 
 	if err != nil {
@@ -224,6 +497,19 @@ func (c *GatewayConnection) OnAuthentication(userID Snowflake, token string) {
 	c.session = GetRandom256()
 
 	gw.AddConnection(c)
+	gw.UpdatePresence(userID)
+}
+
+// Any sign of life from the client pushes the read deadline back
+func (c *GatewayConnection) Heartbeat() {
+	if c.closing.Load() {
+		return
+	}
+	c.ws.SetReadDeadline(time.Now().Add(Config.HeartbeatTimeout))
+}
+
+func (c *GatewayConnection) Detached() bool {
+	return c.detachedAt.Load() != 0
 }
 
 func (c *GatewayConnection) Connected() bool {
@@ -231,7 +517,7 @@ func (c *GatewayConnection) Connected() bool {
 		return false
 	}
 
-	if c.closing {
+	if c.closing.Load() {
 		return false
 	}
 
@@ -250,10 +536,16 @@ func (c *GatewayConnection) Authenticated() bool {
 	return true
 }
 
-func (c *GatewayConnection) Introduction(token string) {
+func (c *GatewayConnection) Introduction(token string, resume ResumeRequest) {
 	db, _ := storage.OpenConnection(c.ctx)
 	defer storage.CloseConnection(db)
 
+	if token != "" && resume.Session != "" {
+		if c.TryResume(token, resume, db) {
+			return
+		}
+	}
+
 	if token != "" {
 		c.TryAuthenticate(token, db)
 	}
@@ -267,7 +559,7 @@ func (c *GatewayConnection) Introduction(token string) {
 func (c *GatewayConnection) Process() {
 	msg, err := c.Read()
 	if err != nil {
-		c.closing = true
+		c.closing.Store(true)
 		return
 	}
 
@@ -289,6 +581,84 @@ func (c *GatewayConnection) Process() {
 		}
 	} else {
 		switch msg.Type {
+		case EventTypeLogoutRequest:
+			c.HandleLogoutRequest(msg, db)
+			break
+		case EventTypeSessionsRequest:
+			c.HandleSessionsRequest(msg, db)
+			break
+		case EventTypeSessionRevoke:
+			c.HandleSessionRevokeRequest(msg, db)
+			break
+		case EventTypeSessionRevokeOthers:
+			c.HandleSessionRevokeOthersRequest(msg, db)
+			break
+		case EventTypePasswordChange:
+			c.HandlePasswordChangeRequest(msg, db)
+			break
+		case EventTypeInviteCodeAdd:
+			c.HandleInviteCodeAddRequest(msg, db)
+			break
+		case EventTypeInviteCodeInvalidate:
+			c.HandleInviteCodeInvalidateRequest(msg, db)
+			break
+		case EventTypeInviteCodesRequest:
+			c.HandleInviteCodesRequest(msg, db)
+			break
+		case EventTypeUserKick:
+			c.HandleUserKickRequest(msg, db)
+			break
+		case EventTypeUserBan:
+			c.HandleUserBanRequest(msg, db)
+			break
+		case EventTypeUserSilence:
+			c.HandleUserSilenceRequest(msg, db)
+			break
+		case EventTypeAuditLogRequest:
+			c.HandleAuditLogRequest(msg, db)
+			break
+		case EventTypeSearchRequest:
+			c.HandleSearchRequest(msg, db)
+			break
+		case EventTypeDMOpen:
+			c.HandleDMOpenRequest(msg, db)
+			break
+		case EventTypeGroupDMAdd:
+			c.HandleGroupDMAddRequest(msg, db)
+			break
+		case EventTypeGroupDMLeave:
+			c.HandleGroupDMLeaveRequest(msg, db)
+			break
+		case EventTypeThreadCreate:
+			c.HandleThreadCreateRequest(msg, db)
+			break
+		case EventTypeThreadsRequest:
+			c.HandleThreadsRequest(msg, db)
+			break
+		case EventTypeMessageAck:
+			c.HandleMessageAckRequest(msg, db)
+			break
+		case EventTypeGatewayStatsRequest:
+			c.HandleGatewayStatsRequest(msg, db)
+			break
+		case EventTypeSettingsUpdate:
+			c.HandleSettingsUpdateRequest(msg, db)
+			break
+		case EventTypeUserPresence:
+			c.HandleUserPresenceRequest(msg, db)
+			break
+		case EventTypeUserIdle:
+			c.HandleUserIdleRequest(msg, db)
+			break
+		case EventTypeEmojiAdd:
+			c.HandleEmojiAddRequest(msg, db)
+			break
+		case EventTypeEmojiUpdate:
+			c.HandleEmojiUpdateRequest(msg, db)
+			break
+		case EventTypeEmojiDelete:
+			c.HandleEmojiDeleteRequest(msg, db)
+			break
 		case EventTypeMessagesRequest:
 			c.HandleMessagesRequest(msg, db)
 			break
@@ -301,18 +671,42 @@ func (c *GatewayConnection) Process() {
 		case EventTypeMessageSendRequest:
 			c.HandleMessageSendRequest(msg, db)
 			break
+		case EventTypeUserTyping:
+			c.HandleUserTypingRequest(msg, db)
+			break
 		case EventTypeMessageUpdate:
 			c.HandleMessageUpdateRequest(msg, db)
 			break
 		case EventTypeMessageDelete:
 			c.HandleMessageDeleteRequest(msg, db)
 			break
+		case EventTypeMessageDeleteBulk:
+			c.HandleMessageDeleteBulkRequest(msg, db)
+			break
+		case EventTypeMessagePurge:
+			c.HandleMessagePurgeRequest(msg, db)
+			break
+		case EventTypeMessagePin:
+			c.HandleMessagePinRequest(msg, db)
+			break
+		case EventTypeMessageUnpin:
+			c.HandleMessageUnpinRequest(msg, db)
+			break
+		case EventTypePinnedMessagesRequest:
+			c.HandlePinnedMessagesRequest(msg, db)
+			break
 		case EventTypeMessageReactionAdd:
 			c.HandleMessageReactionAddRequest(msg, db)
 			break
 		case EventTypeMessageReactionDelete:
 			c.HandleMessageReactionDeleteRequest(msg, db)
 			break
+		case EventTypeMessageReactionDeleteAll:
+			c.HandleMessageReactionDeleteAllRequest(msg, db)
+			break
+		case EventTypeMessageReactionDeleteEmoji:
+			c.HandleMessageReactionDeleteEmojiRequest(msg, db)
+			break
 		case EventTypeMessageReactionUsersRequest:
 			c.HandleMessageReactionUsersRequest(msg, db)
 			break
@@ -334,6 +728,24 @@ func (c *GatewayConnection) Process() {
 		case EventTypeUserRoleDelete:
 			c.HandleUserRoleDeleteRequest(msg, db)
 			break
+		case EventTypeChannelAdd:
+			c.HandleChannelAddRequest(msg, db)
+			break
+		case EventTypeChannelUpdate:
+			c.HandleChannelUpdateRequest(msg, db)
+			break
+		case EventTypeChannelReorder:
+			c.HandleChannelReorderRequest(msg, db)
+			break
+		case EventTypeChannelDelete:
+			c.HandleChannelDeleteRequest(msg, db)
+			break
+		case EventTypeChannelOverwriteSet:
+			c.HandleChannelOverwriteSetRequest(msg, db)
+			break
+		case EventTypeChannelOverwriteDelete:
+			c.HandleChannelOverwriteDeleteRequest(msg, db)
+			break
 		default:
 			c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		}
@@ -342,46 +754,81 @@ func (c *GatewayConnection) Process() {
 	storage.CloseConnection(db)
 }
 
-func (c *GatewayConnection) Run(token string) {
+func (c *GatewayConnection) Run(token string, resume ResumeRequest) {
 	defer c.ws.Close()
+	defer gw.DetachConnection(c)
+	defer gw.RemoveGuest(c)
+	defer close(c.done)
+
+	gw.AddGuest(c)
+
+	c.Heartbeat()
+	c.ws.SetPongHandler(func(string) error {
+		c.Heartbeat()
+		return nil
+	})
 
 	go func() {
+		ping := time.NewTicker(Config.HeartbeatInterval)
+		defer ping.Stop()
+
 		for {
 			select {
 			case <-c.ctx.Done():
 				c.Close()
 				return
-			case msg := <-c.queue:
-				if msg != nil {
-					c.WriteUnsolicited(*msg)
+			case <-c.done:
+				return
+			case <-ping.C:
+				if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(Config.HeartbeatTimeout)); err != nil {
+					gwLog.Printf("Failed to ping: %v", err)
 				}
+			case data := <-c.queue:
+				c.writeData(data)
+				c.queueMutex.Lock()
+				c.queuedBytes -= len(data)
+				c.queueMutex.Unlock()
+			case <-c.userListNotify:
+				last := c.lastUserListRange
+				resp := gw.GetIndex().GetUserListSlice(c.userID, last.From, last.To, 128)
+				c.WriteUnsolicited(Event{
+					Type: EventTypeUserListResponse,
+					Data: resp,
+				})
 			}
 		}
 	}()
 
-	c.Introduction(token)
+	c.Introduction(token, resume)
+	c.FinishIntroduction()
 
-	for c.ctx.Err() == nil && !c.closing {
+	for c.ctx.Err() == nil && !c.closing.Load() {
 		c.Process()
 	}
 }
 
-func HandleGatewayConnection(ctx context.Context, conn *websocket.Conn, token string) {
+func HandleGatewayConnection(ctx context.Context, conn *websocket.Conn, token string, resume ResumeRequest) {
 	gwLog.Printf("Connection from %s", conn.RemoteAddr().String())
 
 	c := &GatewayConnection{
 		ws:      conn,
 		ctx:     ctx,
 		session: GetRandom256(),
-		queue:   make(chan *Event, 16),
-		closing: false,
+		queue:   make(chan []byte, QueueCountBudget),
+		done:    make(chan struct{}),
+		buffer:  NewEventBuffer(ResumeBufferSize),
+
+		introducing: true,
+
+		userListNotify: make(chan struct{}, 1),
+
 		lastUserListRange: IndexRange{
 			From: 0,
 			To:   0,
 		},
 	}
 
-	c.Run(token)
+	c.Run(token, resume)
 
 	gwLog.Printf("Connection from %s closed", conn.RemoteAddr().String())
 }
@@ -466,6 +913,9 @@ func HandleGatewayUpload(ctx context.Context, slotID Snowflake, mr *multipart.Re
 	case EventTypeUserUpdate:
 		conn.HandleUserUpdateUpload(pending.requestData.(*UserUpdateRequest), pending, &reader)
 		break
+	case EventTypeEmojiAdd:
+		conn.HandleEmojiAddUpload(pending.requestData.(*EmojiAddRequest), pending, &reader)
+		break
 	default:
 		break
 	}
@@ -502,6 +952,30 @@ func StartGateway(ctx *common.ClackContext) {
 		}
 	}(gwCtx)
 
+	go func(ctx context.Context) {
+		// Clear typing indicators that were never stopped
+		for ctx.Err() == nil {
+			gw.ExpireTyping()
+			time.Sleep(TypingExpiryInterval)
+		}
+	}(gwCtx)
+
+	go func(ctx context.Context) {
+		// Forget sessions that weren't resumed in time
+		for ctx.Err() == nil {
+			gw.ExpireConnections()
+			time.Sleep(ResumeExpiryInterval)
+		}
+	}(gwCtx)
+
+	go func(ctx context.Context) {
+		// Archive threads that have gone quiet
+		for ctx.Err() == nil {
+			gw.ArchiveInactiveThreads()
+			time.Sleep(ThreadArchiveInterval)
+		}
+	}(gwCtx)
+
 	go func(ctx context.Context) {
 		// Push user list changes to clients
 		for ctx.Err() == nil {
@@ -509,14 +983,13 @@ func StartGateway(ctx *common.ClackContext) {
 			if len(changes) != 0 {
 				gw.connectionsMutex.RLock()
 				for _, c := range gw.connections {
+					if !c.Connected() {
+						continue
+					}
 					last := c.lastUserListRange
 					for _, change := range changes {
 						if last.Overlaps(change) {
-							resp := index.GetUserListSlice(last.From, last.To, 128)
-							c.Write(Event{
-								Type: EventTypeUserListResponse,
-								Data: resp,
-							})
+							c.NotifyUserList()
 							break
 						}
 					}
@@ -534,8 +1007,13 @@ func init() {
 	gw = &Gateway{
 		connectionsMutex: sync.RWMutex{},
 		connections:      make(map[string]*GatewayConnection),
+		userConnections:  make(map[Snowflake]map[string]*GatewayConnection),
+		guests:           make(map[*GatewayConnection]struct{}),
 		pendingMutex:     sync.RWMutex{},
 		pending:          make(map[Snowflake]*PendingRequest),
 		index:            Index{},
+		typing: TypingIndex{
+			entries: make(map[typingKey]*typingEntry),
+		},
 	}
 }
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math"
 	"runtime/debug"
+	"slices"
 	"time"
 
 	"zombiezen.com/go/sqlite"
@@ -36,6 +37,20 @@ func (c *GatewayConnection) HandleError(err error) {
 	})
 }
 
+func NewSettingsResponse(settings Settings, authenticated bool) SettingsResponse {
+	return SettingsResponse{
+		SiteName:           settings.SiteName,
+		LoginMessage:       settings.LoginMessage,
+		DefaultPermissions: settings.DefaultPermissions,
+		Authenticated:      authenticated,
+		UsesEmail:          settings.UsesEmail,
+		UsesInviteCodes:    settings.UsesInviteCodes,
+		UsesCaptcha:        settings.UsesCaptcha,
+		UsesLoginCaptcha:   settings.UsesLoginCaptcha,
+		CaptchSiteKey:      settings.CaptchaSiteKey,
+	}
+}
+
 func (c *GatewayConnection) HandleSettingsRequest(db *sqlite.Conn) {
 	tx := storage.NewTransaction(db)
 	tx.Start()
@@ -49,17 +64,7 @@ func (c *GatewayConnection) HandleSettingsRequest(db *sqlite.Conn) {
 
 	preliminary := Event{
 		Type: EventTypeSettingsResponse,
-		Data: SettingsResponse{
-			SiteName:           settings.SiteName,
-			LoginMessage:       settings.LoginMessage,
-			DefaultPermissions: settings.DefaultPermissions,
-			Authenticated:      c.Authenticated(),
-			UsesEmail:          settings.UsesEmail,
-			UsesInviteCodes:    settings.UsesInviteCodes,
-			UsesCaptcha:        settings.UsesCaptcha,
-			UsesLoginCaptcha:   settings.UsesLoginCaptcha,
-			CaptchSiteKey:      settings.CaptchaSiteKey,
-		},
+		Data: NewSettingsResponse(settings, c.Authenticated()),
 	}
 
 	c.Write(preliminary)
@@ -90,7 +95,7 @@ func (c *GatewayConnection) HandleLoginRequest(msg *UnknownEvent, db *sqlite.Con
 		}
 	}
 
-	userID, token, err := tx.Login(req.Username, req.Password)
+	userID, token, err := tx.Login(req.Username, req.Password, c.ClientIP())
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
@@ -134,9 +139,22 @@ func (c *GatewayConnection) HandleRegisterRequest(msg *UnknownEvent, db *sqlite.
 		}
 	}
 
-	fmt.Println("Registering user", req.Username, req.Password, req.Email, req.InviteCode)
+	inviteCode := ""
+	invitedBy := Snowflake(0)
+	if settings.UsesInviteCodes {
+		invite, err := tx.UseInviteCode(req.InviteCode)
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+		inviteCode = invite.Code
+		invitedBy = invite.UserID
+	}
+
+	fmt.Println("Registering user", req.Username, req.Email, inviteCode)
 
-	user, token, err := tx.Register(req.Username, req.Password, req.Email, req.InviteCode)
+	user, token, err := tx.Register(req.Username, req.Password, req.Email, inviteCode, invitedBy, c.ClientIP())
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
@@ -166,6 +184,261 @@ func (c *GatewayConnection) HandleRegisterRequest(msg *UnknownEvent, db *sqlite.
 	)
 }
 
+func (c *GatewayConnection) HandleLogoutRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	if err := tx.DeleteToken(c.token); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gw.CloseConnectionsByToken(c.token, ErrorCodeInvalidToken)
+}
+
+func (c *GatewayConnection) HandleSessionsRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	sessions, _, err := tx.GetSessions(c.userID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	current := storage.GetSessionID(c.token)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	c.Write(Event{
+		Type: EventTypeSessionsResponse,
+		Data: SessionsResponse{
+			Sessions: sessions,
+		},
+	})
+}
+
+func (c *GatewayConnection) HandleSessionRevokeRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req SessionRevokeRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	sessions, tokens, err := tx.GetSessions(c.userID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	token := ""
+	for i, session := range sessions {
+		if session.ID == req.SessionID {
+			token = tokens[i]
+			break
+		}
+	}
+
+	if token == "" {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if err := tx.DeleteToken(token); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	c.HandleSessionsRequest(msg, db)
+
+	gw.CloseConnectionsByToken(token, ErrorCodeInvalidToken)
+}
+
+func (c *GatewayConnection) HandleSessionRevokeOthersRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	if err := tx.DeleteTokens(c.userID, c.token); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	c.HandleSessionsRequest(msg, db)
+
+	gw.CloseConnectionsByUser(c.userID, c.token, ErrorCodeInvalidToken)
+}
+
+func (c *GatewayConnection) HandlePasswordChangeRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req PasswordChangeRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	if err := tx.CheckUserPassword(c.userID, req.OldPassword); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.SetUserPassword(c.userID, req.NewPassword); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.DeleteTokens(c.userID, c.token); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	c.Write(Event{
+		Type: EventTypeTokenResponse,
+		Data: TokenResponse{
+			Token: c.token,
+		},
+	})
+
+	gw.CloseConnectionsByUser(c.userID, c.token, ErrorCodeInvalidToken)
+}
+
+func (c *GatewayConnection) HandleInviteCodeAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req InviteCodeAddRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if req.ExpiresAt < 0 || req.MaxUses < 0 {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionInviteMembers == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	if _, err := tx.AddInviteCode(c.userID, req.ExpiresAt, req.MaxUses); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	c.FinalizeInviteCodesRequest(tx, perms)
+}
+
+func (c *GatewayConnection) HandleInviteCodeInvalidateRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req InviteCodeInvalidateRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionInviteMembers == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	invite, err := tx.GetInviteCode(req.Code)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if invite.UserID != c.userID && perms&PermissionAdministrator == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	if err := tx.InvalidateInviteCode(invite.Code); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, invite.UserID, AuditLogActionInviteCodeInvalidate, invite, nil, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	c.FinalizeInviteCodesRequest(tx, perms)
+}
+
+func (c *GatewayConnection) HandleInviteCodesRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionInviteMembers == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	c.FinalizeInviteCodesRequest(tx, perms)
+}
+
+// Administrators see every code, everyone else only the codes they created
+func (c *GatewayConnection) FinalizeInviteCodesRequest(tx *storage.Transaction, perms int) {
+	var codes []InviteCode
+	var err error
+	if perms&PermissionAdministrator != 0 {
+		codes, err = tx.GetAllInviteCodes()
+	} else {
+		codes, err = tx.GetInviteCodes(c.userID)
+	}
+
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	c.Write(Event{
+		Type: EventTypeInviteCodesResponse,
+		Data: InviteCodesResponse{
+			Codes: codes,
+		},
+	})
+}
+
 func (c *GatewayConnection) UpdateLastUserListRequest(start, end int) {
 	c.lastUserListRange.From = start
 	c.lastUserListRange.To = end
@@ -175,7 +448,7 @@ func (c *GatewayConnection) HandleOverviewRequest(db *sqlite.Conn) {
 	index := gw.GetIndex()
 
 	c.UpdateLastUserListRequest(0, 20)
-	userList := index.GetUserListSlice(0, 20, 20)
+	userList := index.GetUserListSlice(c.userID, 0, 20, 20)
 
 	users := []User{}
 	for _, id := range userList.Slice {
@@ -183,7 +456,7 @@ func (c *GatewayConnection) HandleOverviewRequest(db *sqlite.Conn) {
 		if !ok {
 			continue
 		}
-		users = append(users, user)
+		users = append(users, user.AsSeenBy(c.userID))
 	}
 
 	roles := index.GetAllRoles()
@@ -191,7 +464,27 @@ func (c *GatewayConnection) HandleOverviewRequest(db *sqlite.Conn) {
 	tx := storage.NewTransaction(db)
 	tx.Start()
 
-	channels, _ := tx.GetAllChannels()
+	allChannels, _ := tx.GetAllChannels()
+
+	channels := []Channel{}
+	visible := []Snowflake{}
+	for _, channel := range allChannels {
+		// Archived threads are fetched on demand
+		if channel.Type == ChannelTypeThread && channel.Archived {
+			continue
+		}
+		if index.GetPermissionsByChannel(c.userID, channel.ID)&PermissionViewChannel == 0 {
+			continue
+		}
+		channels = append(channels, channel)
+		visible = append(visible, channel.ID)
+	}
+
+	readStates, err := tx.GetReadStates(c.userID, visible)
+	if err != nil {
+		readStates = []ReadState{}
+	}
+	emojis, _ := tx.GetAllEmojis()
 	you, _ := index.GetUser(c.userID)
 
 	tx.Commit(nil)
@@ -199,11 +492,14 @@ func (c *GatewayConnection) HandleOverviewRequest(db *sqlite.Conn) {
 	overview := Event{
 		Type: EventTypeOverviewResponse,
 		Data: OverviewResponse{
-			You:      you,
-			Channels: channels,
-			Roles:    roles,
-			UserList: userList,
-			Users:    users,
+			Session:    c.session,
+			You:        you,
+			Channels:   channels,
+			ReadStates: readStates,
+			Roles:      roles,
+			Emojis:     emojis,
+			UserList:   userList,
+			Users:      users,
 		},
 	}
 
@@ -219,6 +515,13 @@ func (c *GatewayConnection) HandleMessagesRequest(msg *UnknownEvent, db *sqlite.
 	var err error = nil
 	var msgs []Message = nil
 
+	index := gw.GetIndex()
+	perms := index.GetPermissionsByChannel(c.userID, req.ChannelID)
+	if perms&PermissionViewChannel == 0 || perms&PermissionReadMessageHistory == 0 {
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
 	haveBefore := req.Before != 0
 	haveAfter := req.After != 0
 
@@ -298,8 +601,13 @@ func (c *GatewayConnection) HandleUsersRequest(msg *UnknownEvent, db *sqlite.Con
 
 	index := gw.GetIndex()
 
+	users := index.GetUsers(req.Users)
+	for i := range users {
+		users[i] = users[i].AsSeenBy(c.userID)
+	}
+
 	resp := UsersResponse{
-		Users: index.GetUsers(req.Users),
+		Users: users,
 	}
 
 	c.Write(Event{
@@ -319,7 +627,7 @@ func (c *GatewayConnection) HandleUserListRequest(msg *UnknownEvent, db *sqlite.
 
 	c.UpdateLastUserListRequest(req.Start, req.End)
 
-	resp := index.GetUserListSlice(req.Start, req.End, 128)
+	resp := index.GetUserListSlice(c.userID, req.Start, req.End, 128)
 
 	c.Write(Event{
 		Type: EventTypeUserListResponse,
@@ -360,7 +668,7 @@ func (c *GatewayConnection) HandleMessageSendRequest(msg *UnknownEvent, db *sqli
 		full.ReferenceID = req.ReferenceID
 	}
 
-	mentionedUsers, mentionedRoles, mentionedChannels, embeddableURLs := ParseMessageContent(req.Content)
+	mentionedUsers, mentionedRoles, mentionedChannels, emojis, embeddableURLs := ParseMessageContent(req.Content)
 
 	if !canEmbedLinks {
 		embeddableURLs = nil
@@ -369,6 +677,8 @@ func (c *GatewayConnection) HandleMessageSendRequest(msg *UnknownEvent, db *sqli
 	full.MentionedUsers = mentionedUsers
 	full.MentionedRoles = mentionedRoles
 	full.MentionedChannels = mentionedChannels
+	full.Emojis = emojis
+	full.MentionsEveryone = perms&PermissionMentionEveryone != 0 && ParseEveryoneMention(req.Content)
 	full.EmbeddableURLs = embeddableURLs
 
 	if req.AttachmentCount > 0 {
@@ -461,6 +771,33 @@ func (c *GatewayConnection) FinalizeMessageSendRequest(rawMessage *Message, seq
 		reference, _ = tx.GetMessage(message.ReferenceID)
 	}
 
+	// Sending implies having read the channel
+	if err := tx.SetReadState(message.AuthorID, message.ChannelID, message.ID); err != nil {
+		tx.Commit(err)
+		return err
+	}
+
+	index := gw.GetIndex()
+
+	// Replies keep a thread active and refresh the summary on its starter message
+	thread, isThread := index.GetChannel(message.ChannelID)
+	isThread = isThread && thread.Type == ChannelTypeThread
+	wasArchived := thread.Archived
+	starter := Message{}
+	if isThread {
+		if err := tx.SetThreadActivity(thread.ID, message.Timestamp); err != nil {
+			tx.Commit(err)
+			return err
+		}
+		if thread, err = tx.GetChannel(thread.ID); err != nil {
+			tx.Commit(err)
+			return err
+		}
+		if thread.MessageID != 0 {
+			starter, _ = tx.GetMessage(thread.MessageID)
+		}
+	}
+
 	tx.Commit(nil)
 
 	c.Write(Event{
@@ -471,15 +808,28 @@ func (c *GatewayConnection) FinalizeMessageSendRequest(rawMessage *Message, seq
 		},
 	})
 
-	index := gw.GetIndex()
 	user, _ := index.GetUser(message.AuthorID)
 
+	gw.StopTyping(message.AuthorID, message.ChannelID)
+
 	gw.OnMessageAdd(&MessageAddEvent{
 		Message:   message,
 		Reference: reference,
 		Author:    user,
 	})
 
+	if isThread {
+		index.UpdateChannel(thread)
+
+		if wasArchived {
+			gw.OnChannelUpdate(&ChannelUpdateEvent{Channel: thread})
+		}
+
+		if starter.ID != 0 {
+			gw.OnMessageUpdate(&MessageUpdateEvent{Message: starter})
+		}
+	}
+
 	if len(message.EmbeddableURLs) > 0 {
 		go c.TryEmbedURLs(message.ID, message.EmbeddableURLs, db)
 	}
@@ -487,33 +837,56 @@ func (c *GatewayConnection) FinalizeMessageSendRequest(rawMessage *Message, seq
 	return nil
 }
 
-func (c *GatewayConnection) HandleMessageUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req MessageUpdateRequest
+func (c *GatewayConnection) HandleUserTypingRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserTypingRequest
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
-	tx := storage.NewTransaction(db)
-	tx.Start()
-
-	full, err := tx.GetMessage(req.MessageID)
-	if err != nil {
-		tx.Commit(err)
+	index := gw.GetIndex()
+	if _, ok := index.GetChannel(req.ChannelID); !ok {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
-	if full.AuthorID != c.userID {
-		tx.Commit(nil)
+	perms := index.GetPermissionsByChannel(c.userID, req.ChannelID)
+	if perms&PermissionSendMessages == 0 {
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	gw.StartTyping(c.userID, req.ChannelID)
+}
+
+func (c *GatewayConnection) HandleMessageUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req MessageUpdateRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	full, err := tx.GetMessage(req.MessageID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if full.AuthorID != c.userID {
+		tx.Commit(nil)
 		c.HandleError(NewError(ErrorCodeNoPermission, nil))
 		return
 	}
 
 	perms := tx.GetPermissionsByChannel(c.userID, full.ChannelID)
 	canEmbedLinks := perms&PermissionEmbedLinks != 0
+	mentionsEveryone := perms&PermissionMentionEveryone != 0 && ParseEveryoneMention(req.Content)
 
-	mentionedUsers, mentionedRoles, mentionedChannels, embeddableURLs := ParseMessageContent(req.Content)
+	mentionedUsers, mentionedRoles, mentionedChannels, emojis, embeddableURLs := ParseMessageContent(req.Content)
 
 	deletedEmbeds := make([]Snowflake, 0)
 	addedURLs := make([]string, 0)
@@ -544,7 +917,7 @@ func (c *GatewayConnection) HandleMessageUpdateRequest(msg *UnknownEvent, db *sq
 		}
 	}
 
-	if err := tx.SetMessage(req.MessageID, req.Content, mentionedUsers, mentionedRoles, mentionedChannels, deletedEmbeds); err != nil {
+	if err := tx.SetMessage(req.MessageID, req.Content, mentionsEveryone, mentionedUsers, mentionedRoles, mentionedChannels, emojis, deletedEmbeds); err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
@@ -604,6 +977,15 @@ func (c *GatewayConnection) HandleMessageDeleteRequest(msg *UnknownEvent, db *sq
 		return
 	}
 
+	if msgRow.AuthorID != c.userID {
+		err := tx.AddAuditLogEntry(c.userID, msgRow.AuthorID, AuditLogActionMessageDelete, msgRow, nil, "")
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	}
+
 	tx.Commit(nil)
 
 	gw.OnMessageDelete(
@@ -614,75 +996,98 @@ func (c *GatewayConnection) HandleMessageDeleteRequest(msg *UnknownEvent, db *sq
 	)
 }
 
-func (c *GatewayConnection) HandleMessageReactionAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req ReactionAddRequest
+const MessageDeleteBulkMax = 100
+const MessagePurgeMax = 1000
+
+func (c *GatewayConnection) HandleMessageDeleteBulkRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req MessageDeleteBulkRequest
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
+	if len(req.MessageIDs) == 0 || len(req.MessageIDs) > MessageDeleteBulkMax {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
 	tx := storage.NewTransaction(db)
 	tx.Start()
 
-	perms := tx.GetPermissionsByMessage(c.userID, req.MessageID)
-
-	channelID, err := tx.GetChannelByMessage(req.MessageID)
-	if err != nil {
+	perms := tx.GetPermissionsByChannel(c.userID, req.ChannelID)
+	if perms&PermissionManageMessages == 0 {
+		err := NewError(ErrorCodeNoPermission, nil)
 		tx.Commit(err)
-		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		c.HandleError(err)
 		return
 	}
 
-	count, err := tx.GetReactionCount(req.MessageID, req.EmojiID)
+	// Messages from other channels are ignored
+	ids, err := tx.FilterChannelMessages(req.ChannelID, req.MessageIDs)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	if count == 0 && perms&PermissionAddReactions == 0 {
+	c.FinalizeMessageDeleteBulk(tx, req.ChannelID, ids)
+}
+
+func (c *GatewayConnection) HandleMessagePurgeRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req MessagePurgeRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if req.Limit <= 0 || req.Limit > MessagePurgeMax {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	perms := tx.GetPermissionsByChannel(c.userID, req.ChannelID)
+	if perms&PermissionManageMessages == 0 {
 		err := NewError(ErrorCodeNoPermission, nil)
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	if err := tx.AddReaction(req.MessageID, c.userID, req.EmojiID); err != nil {
+	ids, err := tx.GetChannelMessageIDs(req.ChannelID, req.AuthorID, req.After, req.Before, req.Limit)
+	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	tx.Commit(nil)
-
-	gw.OnReactionAdd(
-		&ReactionAddEvent{
-			MessageID: req.MessageID,
-			UserID:    c.userID,
-			EmojiID:   req.EmojiID,
-		},
-		channelID,
-	)
+	c.FinalizeMessageDeleteBulk(tx, req.ChannelID, ids)
 }
 
-func (c *GatewayConnection) HandleMessageReactionDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req ReactionDeleteRequest
-	if err := json.Unmarshal(msg.Data, &req); err != nil {
-		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+// Deletes the messages and commits the transaction, then removes their files and tells the channel
+func (c *GatewayConnection) FinalizeMessageDeleteBulk(tx *storage.Transaction, channelID Snowflake, ids []Snowflake) {
+	// Nothing matched, so there is no relay to answer the request
+	if len(ids) == 0 {
+		tx.Commit(nil)
+		c.Write(Event{
+			Type: EventTypeMessageDeleteBulk,
+			Data: MessageDeleteBulkEvent{
+				ChannelID:  channelID,
+				MessageIDs: []Snowflake{},
+			},
+		})
 		return
 	}
 
-	tx := storage.NewTransaction(db)
-	tx.Start()
-
-	channelID, err := tx.GetChannelByMessage(req.MessageID)
-	if err != nil {
+	if err := tx.DeleteMessages(ids); err != nil {
 		tx.Commit(err)
-		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		c.HandleError(err)
 		return
 	}
 
-	if err := tx.DeleteReaction(req.MessageID, c.userID, req.EmojiID); err != nil {
+	if err := tx.AddAuditLogEntry(c.userID, channelID, AuditLogActionMessageDeleteBulk, ids, nil, ""); err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
@@ -690,28 +1095,48 @@ func (c *GatewayConnection) HandleMessageReactionDeleteRequest(msg *UnknownEvent
 
 	tx.Commit(nil)
 
-	gw.OnReactionDelete(
-		&ReactionDeleteEvent{
-			MessageID: req.MessageID,
-			UserID:    c.userID,
-			EmojiID:   req.EmojiID,
+	for _, id := range ids {
+		if err := storage.DeleteMessageFiles(id); err != nil {
+			gwLog.Printf("Failed to delete files of message %d: %v", id, err)
+		}
+	}
+
+	gw.OnMessageDeleteBulk(
+		&MessageDeleteBulkEvent{
+			ChannelID:  channelID,
+			MessageIDs: ids,
 		},
-		channelID,
 	)
 }
 
-func (c *GatewayConnection) HandleMessageReactionUsersRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req ReactionUsersRequest
+func (c *GatewayConnection) HandleMessageAckRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req MessageAckRequest
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
+	index := gw.GetIndex()
+	perms := index.GetPermissionsByChannel(c.userID, req.ChannelID)
+	if perms&PermissionViewChannel == 0 {
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
 	tx := storage.NewTransaction(db)
 	tx.Start()
 
-	users, err := tx.GetReactionUsers(req.MessageID, req.EmojiID)
-	if err != nil {
+	if err := tx.SetReadState(c.userID, req.ChannelID, req.MessageID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	states, err := tx.GetReadStates(c.userID, []Snowflake{req.ChannelID})
+	if err != nil || len(states) == 0 {
+		if err == nil {
+			err = NewError(ErrorCodeInvalidRequest, nil)
+		}
 		tx.Commit(err)
 		c.HandleError(err)
 		return
@@ -719,214 +1144,2030 @@ func (c *GatewayConnection) HandleMessageReactionUsersRequest(msg *UnknownEvent,
 
 	tx.Commit(nil)
 
-	c.Write(Event{
-		Type: EventTypeMessageReactionUsersResponse,
-		Data: ReactionUsersResponse{
-			MessageID: req.MessageID,
-			EmojiID:   req.EmojiID,
-			Users:     users,
+	gw.OnReadStateUpdate(
+		&ReadStateUpdateEvent{
+			ReadState: states[0],
 		},
-	})
+		c.userID,
+	)
 }
 
-func (c *GatewayConnection) HandleUserUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req UserUpdateRequest
+func (c *GatewayConnection) HandleMessagePinRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req MessagePinRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	c.SetMessagePinned(req.MessageID, true, db)
+}
+
+func (c *GatewayConnection) HandleMessageUnpinRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req MessageUnpinRequest
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
+	c.SetMessagePinned(req.MessageID, false, db)
+}
+
+func (c *GatewayConnection) SetMessagePinned(messageID Snowflake, pinned bool, db *sqlite.Conn) {
 	tx := storage.NewTransaction(db)
 	tx.Start()
-	user, err := tx.GetUser(req.UserID)
-	perms, _ := tx.GetPermissionsByUser(c.userID)
-	tx.Commit(nil)
 
+	full, err := tx.GetMessage(messageID)
 	if err != nil {
+		tx.Commit(err)
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
-	if user.ID == c.userID && perms&PermissionChangeProfile == 0 {
+	perms := tx.GetPermissionsByChannel(c.userID, full.ChannelID)
+	if perms&PermissionManageMessages == 0 {
 		err := NewError(ErrorCodeNoPermission, nil)
+		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	// Setting other peoples profile, narrow what is permitted (only reseting)
-	if user.ID != c.userID {
-		if perms&PermissionManageProfiles == 0 {
-			err := NewError(ErrorCodeNoPermission, nil)
-			c.HandleError(err)
-			return
-		}
-
-		if perms&PermissionAdministrator == 0 {
-			if req.SetAvatar && req.AvatarModified != AvatarModifiedDefault {
-				err := NewError(ErrorCodeNoPermission, nil)
-				c.HandleError(err)
-				return
-			}
-
-			if req.SetProfile {
-				statusAllowed := req.StatusMessage == "" || req.StatusMessage == user.StatusMessage
-				profileAllowed := req.ProfileMessage == "" || req.ProfileMessage == user.ProfileMessage
-				colorAllowed := req.ProfileColor == ProfileColorDefault || req.ProfileColor == user.ProfileColor
-				if !statusAllowed || !profileAllowed || !colorAllowed {
-					err := NewError(ErrorCodeNoPermission, nil)
-					tx.Commit(err)
-					c.HandleError(err)
-					return
-				}
-			}
-		}
+	if full.Pinned == pinned {
+		tx.Commit(nil)
+		return
 	}
 
-	if !req.SetName {
-		req.DisplayName = user.DisplayName
+	if err := tx.SetMessagePinned(messageID, pinned); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
 	}
 
-	if !req.SetProfile {
-		req.StatusMessage = user.StatusMessage
-		req.ProfileMessage = user.ProfileMessage
-		req.ProfileColor = user.ProfileColor
+	action := AuditLogActionMessagePin
+	if !pinned {
+		action = AuditLogActionMessageUnpin
+	}
+	if err := tx.AddAuditLogEntry(c.userID, messageID, action, full.Pinned, pinned, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
 	}
 
-	if !req.SetAvatar {
-		req.AvatarModified = user.AvatarModified
+	full, err = tx.GetMessage(messageID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
 	}
 
-	if req.SetAvatar && req.AvatarModified != 0 {
-		// They want to upload an avatar, send an upload slot and pend for it
-		slotID := snowflake.New()
-		pending := PendingRequest{
-			slotID:      slotID,
-			requestData: &req,
-			requestType: EventTypeUserUpdate,
-			seq:         msg.Seq,
-			session:     c.session,
-		}
+	tx.Commit(nil)
 
-		gw.PushPendingRequest(&pending, slotID)
+	gw.OnChannelPinsUpdate(&ChannelPinsUpdateEvent{
+		ChannelID: full.ChannelID,
+		MessageID: full.ID,
+		Pinned:    pinned,
+	})
 
-		c.Write(Event{
-			Type: EventTypeUploadSlot,
-			Seq:  pending.seq,
-			Data: MessageUploadSlot{
-				SlotID: slotID,
-			},
-		})
-	} else {
-		c.FinalizeUserUpdateRequest(&req, db)
-	}
+	gw.OnMessageUpdate(&MessageUpdateEvent{
+		Message: full,
+	})
 }
 
-func (c *GatewayConnection) HandleUserUpdateUpload(req *UserUpdateRequest, pending *PendingRequest, reader *UploadReader) {
-	db, _ := storage.OpenConnection(c.ctx)
-	defer storage.CloseConnection(db)
-
-	modified := time.Now().UnixMilli()
-	req.AvatarModified = int(modified)
+func (c *GatewayConnection) HandlePinnedMessagesRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req PinnedMessagesRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
 
-	err := reader.ReadFiles(func(_ string, reader FileInputReader) error {
-		storage.UploadAvatar(req.UserID, modified, reader)
-		return nil
-	})
-	if err != nil {
-		c.HandleError(err)
+	index := gw.GetIndex()
+	perms := index.GetPermissionsByChannel(c.userID, req.ChannelID)
+	if perms&PermissionViewChannel == 0 || perms&PermissionReadMessageHistory == 0 {
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
 	}
 
-	c.FinalizeUserUpdateRequest(req, db)
-}
+	req.Limit = ClampInt(req.Limit, 1, 50)
 
-func (c *GatewayConnection) FinalizeUserUpdateRequest(req *UserUpdateRequest, db *sqlite.Conn) {
 	tx := storage.NewTransaction(db)
 	tx.Start()
 
-	err := tx.SetUserProfile(c.userID, req.DisplayName, req.StatusMessage, req.ProfileMessage, req.ProfileColor, req.AvatarModified)
-	if err != nil {
-		tx.Commit(err)
-		c.HandleError(err)
-		return
-	}
-
-	user, err := tx.GetUser(c.userID)
+	msgs, err := tx.GetPinnedMessages(req.ChannelID, req.Before, req.Limit)
 	if err != nil {
-		tx.Commit(err)
+		tx.Commit(nil)
 		c.HandleError(err)
 		return
 	}
 
 	tx.Commit(nil)
 
-	index := gw.GetIndex()
-	user = index.UpdateUser(user)
-
-	gw.OnUserUpdate(
-		&UserUpdateEvent{
-			User: user,
+	c.Write(Event{
+		Type: EventTypePinnedMessagesResponse,
+		Data: PinnedMessagesResponse{
+			ChannelID: req.ChannelID,
+			Before:    req.Before,
+			Limit:     req.Limit,
+			Messages:  msgs,
 		},
-	)
+	})
 }
 
-func (c *GatewayConnection) TryEmbedURLs(id Snowflake, urls []string, db *sqlite.Conn) {
+func (c *GatewayConnection) HandleSearchRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req SearchRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	req.Limit = ClampInt(req.Limit, 1, 50)
+
+	index := gw.GetIndex()
+	search, err := ParseSearchQuery(index, c.userID, req.Query)
+	if err != nil {
+		c.HandleError(err)
+		return
+	}
+	search.Before = req.Before
+	search.Limit = req.Limit
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	msgs, err := tx.SearchMessages(search)
+	if err != nil {
+		tx.Commit(nil)
+		c.HandleError(err)
+		return
+	}
+
+	referenceIDs := make([]Snowflake, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.ReferenceID != 0 {
+			referenceIDs = append(referenceIDs, msg.ReferenceID)
+		}
+	}
+
+	references := []Message{}
+	if len(referenceIDs) > 0 {
+		references, err = tx.GetMessages(referenceIDs, false)
+		if err != nil {
+			tx.Commit(nil)
+			c.HandleError(err)
+			return
+		}
+	}
+
+	tx.Commit(nil)
+
+	c.Write(Event{
+		Type: EventTypeSearchResponse,
+		Data: SearchResponse{
+			Query:      req.Query,
+			Before:     req.Before,
+			Limit:      req.Limit,
+			Messages:   msgs,
+			References: references,
+		},
+	})
+}
+
+func (c *GatewayConnection) HandleMessageReactionAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ReactionAddRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	perms := tx.GetPermissionsByMessage(c.userID, req.MessageID)
+
+	channelID, err := tx.GetChannelByMessage(req.MessageID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	count, err := tx.GetReactionCount(req.MessageID, req.EmojiID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if count == 0 && perms&PermissionAddReactions == 0 {
+		err := NewError(ErrorCodeNoPermission, nil)
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddReaction(req.MessageID, c.userID, req.EmojiID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gw.OnReactionAdd(
+		&ReactionAddEvent{
+			MessageID: req.MessageID,
+			UserID:    c.userID,
+			EmojiID:   req.EmojiID,
+		},
+		channelID,
+	)
+}
+
+func (c *GatewayConnection) HandleMessageReactionDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ReactionDeleteRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	channelID, err := tx.GetChannelByMessage(req.MessageID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	userID := c.userID
+	if req.UserID != 0 && req.UserID != c.userID {
+		perms := tx.GetPermissionsByChannel(c.userID, channelID)
+		if perms&PermissionManageMessages == 0 {
+			err := NewError(ErrorCodeNoPermission, nil)
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+		userID = req.UserID
+	}
+
+	if err := tx.DeleteReaction(req.MessageID, userID, req.EmojiID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if userID != c.userID {
+		if err := tx.AddAuditLogEntry(c.userID, userID, AuditLogActionReactionDelete, req, nil, ""); err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	}
+
+	tx.Commit(nil)
+
+	gw.OnReactionDelete(
+		&ReactionDeleteEvent{
+			MessageID: req.MessageID,
+			UserID:    userID,
+			EmojiID:   req.EmojiID,
+		},
+		channelID,
+	)
+}
+
+func (c *GatewayConnection) HandleMessageReactionDeleteAllRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ReactionDeleteAllRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	channelID, err := tx.GetChannelByMessage(req.MessageID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	perms := tx.GetPermissionsByChannel(c.userID, channelID)
+	if perms&PermissionManageMessages == 0 {
+		err := NewError(ErrorCodeNoPermission, nil)
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.DeleteAllReactions(req.MessageID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.MessageID, AuditLogActionReactionDeleteAll, req, nil, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gw.OnReactionDeleteAll(
+		&ReactionDeleteAllEvent{
+			MessageID: req.MessageID,
+		},
+		channelID,
+	)
+}
+
+func (c *GatewayConnection) HandleMessageReactionDeleteEmojiRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ReactionDeleteEmojiRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	channelID, err := tx.GetChannelByMessage(req.MessageID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	perms := tx.GetPermissionsByChannel(c.userID, channelID)
+	if perms&PermissionManageMessages == 0 {
+		err := NewError(ErrorCodeNoPermission, nil)
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.DeleteReactionsByEmoji(req.MessageID, req.EmojiID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.MessageID, AuditLogActionReactionDeleteEmoji, req, nil, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gw.OnReactionDeleteEmoji(
+		&ReactionDeleteEmojiEvent{
+			MessageID: req.MessageID,
+			EmojiID:   req.EmojiID,
+		},
+		channelID,
+	)
+}
+
+func (c *GatewayConnection) HandleMessageReactionUsersRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ReactionUsersRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	users, err := tx.GetReactionUsers(req.MessageID, req.EmojiID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	c.Write(Event{
+		Type: EventTypeMessageReactionUsersResponse,
+		Data: ReactionUsersResponse{
+			MessageID: req.MessageID,
+			EmojiID:   req.EmojiID,
+			Users:     users,
+		},
+	})
+}
+
+func (c *GatewayConnection) HandleUserUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserUpdateRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	user, err := tx.GetUser(req.UserID)
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	tx.Commit(nil)
+
+	if err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if user.ID == c.userID && perms&PermissionChangeProfile == 0 {
+		err := NewError(ErrorCodeNoPermission, nil)
+		c.HandleError(err)
+		return
+	}
+
+	// Setting other peoples profile, narrow what is permitted (only reseting)
+	if user.ID != c.userID {
+		if perms&PermissionManageProfiles == 0 {
+			err := NewError(ErrorCodeNoPermission, nil)
+			c.HandleError(err)
+			return
+		}
+
+		if perms&PermissionAdministrator == 0 {
+			if req.SetAvatar && req.AvatarModified != AvatarModifiedDefault {
+				err := NewError(ErrorCodeNoPermission, nil)
+				c.HandleError(err)
+				return
+			}
+
+			if req.SetProfile {
+				statusAllowed := req.StatusMessage == "" || req.StatusMessage == user.StatusMessage
+				profileAllowed := req.ProfileMessage == "" || req.ProfileMessage == user.ProfileMessage
+				colorAllowed := req.ProfileColor == ProfileColorDefault || req.ProfileColor == user.ProfileColor
+				if !statusAllowed || !profileAllowed || !colorAllowed {
+					err := NewError(ErrorCodeNoPermission, nil)
+					tx.Commit(err)
+					c.HandleError(err)
+					return
+				}
+			}
+		}
+	}
+
+	if !req.SetName {
+		req.DisplayName = user.DisplayName
+	}
+
+	if !req.SetProfile {
+		req.StatusMessage = user.StatusMessage
+		req.ProfileMessage = user.ProfileMessage
+		req.ProfileColor = user.ProfileColor
+	}
+
+	if !req.SetAvatar {
+		req.AvatarModified = user.AvatarModified
+	}
+
+	if req.SetAvatar && req.AvatarModified != 0 {
+		// They want to upload an avatar, send an upload slot and pend for it
+		slotID := snowflake.New()
+		pending := PendingRequest{
+			slotID:      slotID,
+			requestData: &req,
+			requestType: EventTypeUserUpdate,
+			seq:         msg.Seq,
+			session:     c.session,
+		}
+
+		gw.PushPendingRequest(&pending, slotID)
+
+		c.Write(Event{
+			Type: EventTypeUploadSlot,
+			Seq:  pending.seq,
+			Data: MessageUploadSlot{
+				SlotID: slotID,
+			},
+		})
+	} else {
+		c.FinalizeUserUpdateRequest(&req, db)
+	}
+}
+
+func (c *GatewayConnection) HandleUserUpdateUpload(req *UserUpdateRequest, pending *PendingRequest, reader *UploadReader) {
+	db, _ := storage.OpenConnection(c.ctx)
+	defer storage.CloseConnection(db)
+
+	modified := time.Now().UnixMilli()
+	req.AvatarModified = int(modified)
+
+	err := reader.ReadFiles(func(_ string, reader FileInputReader) error {
+		storage.UploadAvatar(req.UserID, modified, reader)
+		return nil
+	})
+	if err != nil {
+		c.HandleError(err)
+	}
+
+	c.FinalizeUserUpdateRequest(req, db)
+}
+
+func (c *GatewayConnection) FinalizeUserUpdateRequest(req *UserUpdateRequest, db *sqlite.Conn) {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	err := tx.SetUserProfile(c.userID, req.DisplayName, req.StatusMessage, req.ProfileMessage, req.ProfileColor, req.AvatarModified)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	user, err := tx.GetUser(c.userID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	user = index.UpdateUser(user)
+
+	gw.OnUserUpdate(
+		&UserUpdateEvent{
+			User: user,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleUserPresenceRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserPresence
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	// A non-sticky request hands presence back to the gateway
+	sticky := UserPresenceNone
+	if req.Sticky {
+		switch req.Presence {
+		case UserPresenceOnline, UserPresenceAway, UserPresenceDoNotDisturb, UserPresenceInvisible:
+			sticky = req.Presence
+		default:
+			c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+			return
+		}
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	if err := tx.SetUserPresence(c.userID, sticky); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	if user, ok := index.GetUser(c.userID); ok {
+		user.PresenceSticky = sticky
+		index.UpdateUser(user)
+	}
+
+	gw.UpdatePresence(c.userID)
+}
+
+func (c *GatewayConnection) HandleUserIdleRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserIdleRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil || req.IdleSince < 0 {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	c.idleSince.Store(int64(req.IdleSince))
+
+	gw.UpdatePresence(c.userID)
+}
+
+func (c *GatewayConnection) TryEmbedURLs(id Snowflake, urls []string, db *sqlite.Conn) {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	for _, url := range urls {
+		embed, err := GetEmbedFromURL(context.Background(), id, url)
+		if err != nil || embed == nil {
+			fmt.Println("Failed to get embed from URL:", err)
+			continue
+		}
+		embed.ID = snowflake.New()
+
+		err = tx.AddEmbed(id, embed)
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	}
+	message, err := tx.GetMessage(id)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gw.OnMessageUpdate(&MessageUpdateEvent{
+		Message: message,
+	})
+}
+
+// Role Management Handlers
+func (c *GatewayConnection) HandleRoleAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req RoleAddRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionManageRoles == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	roleID, err := tx.AddRole(req.Name, req.Color, req.Position, req.Permissions, req.Hoisted, req.Mentionable)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	role, err := tx.GetRole(roleID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, role.ID, AuditLogActionRoleAdd, nil, role, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	index.AddRole(role)
+
+	gw.OnRoleAdd(
+		&RoleAddEvent{
+			Role: role,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleRoleUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req RoleUpdateRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionManageRoles == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	before, err := tx.GetRole(req.Role.ID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	role := req.Role
+	if err := tx.UpdateRole(role.ID, role.Name, role.Color, role.Position, role.Permissions, role.Hoisted, role.Mentionable); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	role, err = tx.GetRole(role.ID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, role.ID, AuditLogActionRoleUpdate, before, role, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	index.UpdateRole(role)
+
+	gw.OnRoleUpdate(
+		&RoleUpdateEvent{
+			Role: role,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleRoleDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req RoleDeleteRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionManageRoles == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	before, err := tx.GetRole(req.RoleID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.DeleteRole(req.RoleID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.RoleID, AuditLogActionRoleDelete, before, nil, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	index.DeleteRole(req.RoleID)
+
+	gw.OnRoleDelete(
+		&RoleDeleteEvent{
+			RoleID: req.RoleID,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleUserRoleAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserRoleAddRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionManageRoles == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	targetUser, err := tx.GetUser(req.UserID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	role, err := tx.GetRole(req.RoleID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	for _, roleID := range targetUser.Roles {
+		if roleID == role.ID {
+			tx.Commit(nil)
+			return
+		}
+	}
+
+	actorUser, err := tx.GetUser(c.userID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	roleCache := map[Snowflake]int{role.ID: role.Position}
+	actorRank, err := ComputeEffectiveRank(tx, actorUser, roleCache)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	targetRank, err := ComputeEffectiveRank(tx, targetUser, roleCache)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if req.UserID != c.userID && targetRank <= actorRank {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	if role.Position <= actorRank {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	if err := tx.AddRoleToUser(req.UserID, req.RoleID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	updatedUser, err := tx.GetUser(req.UserID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.UserID, AuditLogActionUserRoleAdd, targetUser.Roles, updatedUser.Roles, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	updatedUser = index.UpdateUser(updatedUser)
+
+	gw.OnUserUpdate(
+		&UserUpdateEvent{User: updatedUser},
+	)
+}
+
+func (c *GatewayConnection) HandleUserRoleDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserRoleDeleteRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.StartTimed("UserRoleDelete")
+
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionManageRoles == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	targetUser, err := tx.GetUser(req.UserID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	role, err := tx.GetRole(req.RoleID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	hasRole := false
+	for _, roleID := range targetUser.Roles {
+		if roleID == role.ID {
+			hasRole = true
+			break
+		}
+	}
+
+	if !hasRole {
+		tx.Commit(nil)
+		return
+	}
+
+	actorUser, err := tx.GetUser(c.userID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	roleCache := map[Snowflake]int{role.ID: role.Position}
+	actorRank, err := ComputeEffectiveRank(tx, actorUser, roleCache)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	targetRank, err := ComputeEffectiveRank(tx, targetUser, roleCache)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if req.UserID != c.userID && targetRank <= actorRank {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	if role.Position <= actorRank {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	if err := tx.DeleteRoleFromUser(req.UserID, req.RoleID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	updatedUser, err := tx.GetUser(req.UserID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.UserID, AuditLogActionUserRoleDelete, targetUser.Roles, updatedUser.Roles, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	updatedUser = index.UpdateUser(updatedUser)
+
+	gw.OnUserUpdate(
+		&UserUpdateEvent{User: updatedUser},
+	)
+}
+
+func ComputeEffectiveRank(tx *storage.Transaction, user User, cache map[Snowflake]int) (int, error) {
+	// Todo: Compute in user query
+
+	rank := math.MaxInt
+	for _, roleID := range user.Roles {
+		if pos, ok := cache[roleID]; ok {
+			if pos < rank {
+				rank = pos
+			}
+			continue
+		}
+		role, err := tx.GetRole(roleID)
+		if err != nil {
+			if cerr, ok := err.(*CodedError); ok && cerr.Code == ErrorCodeInvalidRequest {
+				continue
+			}
+			return 0, err
+		}
+		cache[roleID] = role.Position
+		if role.Position < rank {
+			rank = role.Position
+		}
+	}
+	return rank, nil
+}
+
+// Moderation Handlers
+func (c *GatewayConnection) HandleUserKickRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserKickRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	if err := c.CheckModerationTarget(tx, req.UserID, PermissionKickMembers); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.DeleteTokens(req.UserID, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.UserID, AuditLogActionUserKick, nil, nil, req.Reason); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gwLog.Printf("User %d kicked by %d: %s", req.UserID, c.userID, req.Reason)
+
+	gw.CloseConnectionsByUser(req.UserID, "", ErrorCodeInvalidToken)
+}
+
+func (c *GatewayConnection) HandleUserBanRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserBanRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if req.Duration < 0 {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	if err := c.CheckModerationTarget(tx, req.UserID, PermissionBanMembers); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	target, err := tx.GetUser(req.UserID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	expiresAt := 0
+	if req.Duration != 0 {
+		expiresAt = int(time.Now().UnixMilli()) + req.Duration
+	}
+
+	ips := []string{""}
+	if req.BanIP {
+		sessions, _, err := tx.GetSessions(req.UserID)
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+		for _, session := range sessions {
+			ips = append(ips, session.IP)
+		}
+		ips = append(ips, gw.GetClientIPsByUser(req.UserID)...)
+	}
+
+	banned := map[string]bool{}
+	for _, ip := range ips {
+		if banned[ip] {
+			continue
+		}
+		banned[ip] = true
+
+		if err := tx.AddBan(target.ID, target.UserName, ip, c.userID, req.Reason, expiresAt); err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	}
+
+	if err := tx.DeleteTokens(req.UserID, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.UserID, AuditLogActionUserBan, nil, req, req.Reason); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gwLog.Printf("User %d banned by %d: %s", req.UserID, c.userID, req.Reason)
+
+	gw.CloseConnectionsByUser(req.UserID, "", ErrorCodeBanned)
+}
+
+func (c *GatewayConnection) HandleUserSilenceRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req UserSilenceRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if req.Duration < 0 {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	if err := c.CheckModerationTarget(tx, req.UserID, PermissionSilenceMembers); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	expiresAt := 0
+	if req.Duration != 0 {
+		expiresAt = int(time.Now().UnixMilli()) + req.Duration
+		err := tx.SetUserSilence(req.UserID, c.userID, req.Reason, expiresAt)
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	} else {
+		if err := tx.DeleteUserSilence(req.UserID); err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.UserID, AuditLogActionUserSilence, nil, req, req.Reason); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gwLog.Printf("User %d silenced by %d until %d: %s", req.UserID, c.userID, expiresAt, req.Reason)
+
+	index := gw.GetIndex()
+	index.SetUserSilence(req.UserID, expiresAt)
+}
+
+func (c *GatewayConnection) HandleAuditLogRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req AuditLogRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	req.Limit = ClampInt(req.Limit, 1, 100)
+
+	action := -1
+	if req.Action != nil {
+		action = *req.Action
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionAdministrator == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	entries, err := tx.GetAuditLog(req.Before, req.Limit, req.ActorID, req.TargetID, action)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	c.Write(Event{
+		Type: EventTypeAuditLogResponse,
+		Data: AuditLogResponse{
+			Before:  req.Before,
+			Limit:   req.Limit,
+			Entries: entries,
+		},
+	})
+}
+
+func (c *GatewayConnection) HandleGatewayStatsRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	index := gw.GetIndex()
+	if index.GetPermissionsByUser(c.userID)&PermissionAdministrator == 0 {
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	c.Write(Event{
+		Type: EventTypeGatewayStatsResponse,
+		Data: gw.GetStats(),
+	})
+}
+
+func (c *GatewayConnection) HandleSettingsUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req SettingsUpdateRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionAdministrator == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	before, err := tx.GetSettings()
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	settings := Settings{
+		SiteName:           req.SiteName,
+		LoginMessage:       req.LoginMessage,
+		DefaultPermissions: req.DefaultPermissions,
+		UsesEmail:          req.UsesEmail,
+		UsesInviteCodes:    req.UsesInviteCodes,
+		UsesCaptcha:        req.UsesCaptcha,
+		UsesLoginCaptcha:   req.UsesLoginCaptcha,
+		CaptchaSiteKey:     req.CaptchaSiteKey,
+		CaptchaSecretKey:   before.CaptchaSecretKey,
+	}
+	if req.CaptchaSecretKey != nil {
+		settings.CaptchaSecretKey = *req.CaptchaSecretKey
+	}
+
+	if _, err := tx.IsSettingsValid(settings); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.SetSettings(settings); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	// The secret key isn't serialized, so it never reaches the audit log
+	if err := tx.AddAuditLogEntry(c.userID, 0, AuditLogActionSettingsUpdate, before, settings, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gw.GetIndex().SetSettings(settings)
+	gw.OnSettingsUpdate(settings)
+}
+
+// Checks the actor holds the permission and outranks the target
+func (c *GatewayConnection) CheckModerationTarget(tx *storage.Transaction, targetID Snowflake, permission int) error {
+	if targetID == c.userID {
+		return NewError(ErrorCodeInvalidRequest, nil)
+	}
+
+	perms, actorUser := tx.GetPermissionsByUser(c.userID)
+	if perms&permission == 0 || actorUser == nil {
+		return NewError(ErrorCodeNoPermission, nil)
+	}
+
+	targetUser, err := tx.GetUser(targetID)
+	if err != nil {
+		return err
+	}
+
+	roleCache := map[Snowflake]int{}
+	actorRank, err := ComputeEffectiveRank(tx, *actorUser, roleCache)
+	if err != nil {
+		return err
+	}
+
+	targetRank, err := ComputeEffectiveRank(tx, targetUser, roleCache)
+	if err != nil {
+		return err
+	}
+
+	if targetRank <= actorRank {
+		return NewError(ErrorCodeNoPermission, nil)
+	}
+
+	return nil
+}
+
+// Emoji Handlers
+func (c *GatewayConnection) HandleEmojiAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req EmojiAddRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if !emojiNameRegex.MatchString(req.Name) {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionManageEmojis == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	taken, err := tx.IsEmojiNameTaken(req.Name, 0)
+	if err == nil && taken {
+		err = NewError(ErrorCodeInvalidRequest, nil)
+	}
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	// The image comes through an upload slot, the emoji is added once it's processed
+	req.ID = snowflake.New()
+	slotID := snowflake.New()
+	pending := PendingRequest{
+		slotID:      slotID,
+		requestData: &req,
+		requestType: EventTypeEmojiAdd,
+		seq:         msg.Seq,
+		session:     c.session,
+	}
+
+	gw.PushPendingRequest(&pending, slotID)
+
+	c.Write(Event{
+		Type: EventTypeUploadSlot,
+		Seq:  pending.seq,
+		Data: MessageUploadSlot{
+			SlotID: slotID,
+		},
+	})
+}
+
+func (c *GatewayConnection) HandleEmojiAddUpload(req *EmojiAddRequest, pending *PendingRequest, reader *UploadReader) {
+	db, _ := storage.OpenConnection(c.ctx)
+	defer storage.CloseConnection(db)
+
+	// Only the first file is used, its error is kept as is so the client gets the right code
+	uploaded := false
+	animated := false
+	var uploadErr error = NewError(ErrorCodeInvalidRequest, nil)
+	err := reader.ReadFiles(func(_ string, reader FileInputReader) error {
+		if !uploaded {
+			uploaded = true
+			animated, uploadErr = storage.UploadEmoji(req.ID, reader)
+		}
+		return nil
+	})
+	if err == nil {
+		err = uploadErr
+	}
+	if err != nil {
+		storage.DeleteEmojiImages(req.ID)
+		c.HandleError(err)
+		return
+	}
+
+	c.FinalizeEmojiAddRequest(req, animated, db)
+}
+
+func (c *GatewayConnection) FinalizeEmojiAddRequest(req *EmojiAddRequest, animated bool, db *sqlite.Conn) {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	// Checked again, the name could have been taken while uploading
+	taken, err := tx.IsEmojiNameTaken(req.Name, 0)
+	if err == nil && taken {
+		err = NewError(ErrorCodeInvalidRequest, nil)
+	}
+	if err == nil {
+		err = tx.AddEmoji(req.ID, req.Name, animated, c.userID)
+	}
+	if err != nil {
+		tx.Commit(err)
+		storage.DeleteEmojiImages(req.ID)
+		c.HandleError(err)
+		return
+	}
+
+	emoji, err := tx.GetEmoji(req.ID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, emoji.ID, AuditLogActionEmojiAdd, nil, emoji, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gw.OnEmojiAdd(
+		&EmojiAddEvent{
+			Emoji: emoji,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleEmojiUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req EmojiUpdateRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	if !emojiNameRegex.MatchString(req.Name) {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionManageEmojis == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	before, err := tx.GetEmoji(req.EmojiID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	taken, err := tx.IsEmojiNameTaken(req.Name, req.EmojiID)
+	if err == nil && taken {
+		err = NewError(ErrorCodeInvalidRequest, nil)
+	}
+	if err == nil {
+		err = tx.SetEmojiName(req.EmojiID, req.Name)
+	}
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	emoji, err := tx.GetEmoji(req.EmojiID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, emoji.ID, AuditLogActionEmojiUpdate, before, emoji, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	gw.OnEmojiUpdate(
+		&EmojiUpdateEvent{
+			Emoji: emoji,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleEmojiDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req EmojiDeleteRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	perms, _ := tx.GetPermissionsByUser(c.userID)
+	if perms&PermissionManageEmojis == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	before, err := tx.GetEmoji(req.EmojiID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	// Reactions using it are removed by a trigger, collect them first so clients can be told
+	reacted, err := tx.GetReactionMessagesByEmoji(req.EmojiID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.DeleteEmoji(req.EmojiID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, req.EmojiID, AuditLogActionEmojiDelete, before, nil, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	if err := storage.DeleteEmojiImages(req.EmojiID); err != nil {
+		gwLog.Printf("Failed to delete emoji images: %v", err)
+	}
+
+	for messageID, channelID := range reacted {
+		gw.OnReactionDeleteEmoji(
+			&ReactionDeleteEmojiEvent{
+				MessageID: messageID,
+				EmojiID:   req.EmojiID,
+			},
+			channelID,
+		)
+	}
+
+	gw.OnEmojiDelete(
+		&EmojiDeleteEvent{
+			EmojiID: req.EmojiID,
+		},
+	)
+}
+
+// Channel Management Handlers
+func (c *GatewayConnection) HandleChannelAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ChannelAddRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	perms := tx.GetPermissionsByChannel(c.userID, req.ParentID)
+	if perms&PermissionManageChannels == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	if _, err := tx.IsChannelValid(0, req.Type, req.Name, req.Description, req.ParentID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	channelID, err := tx.AddChannel(req.Name, req.Type, req.Description, req.Position, req.ParentID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	channel, err := tx.GetChannel(channelID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, channel.ID, AuditLogActionChannelAdd, nil, channel, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	index.AddChannel(channel)
+
+	gw.OnChannelAdd(
+		&ChannelAddEvent{
+			Channel: channel,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleChannelUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ChannelUpdateRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
 	tx := storage.NewTransaction(db)
 	tx.Start()
 
-	for _, url := range urls {
-		embed, err := GetEmbedFromURL(context.Background(), id, url)
-		if err != nil || embed == nil {
-			fmt.Println("Failed to get embed from URL:", err)
-			continue
+	current, err := tx.GetChannel(req.Channel.ID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	perms := tx.GetPermissionsByChannel(c.userID, current.ID)
+	if perms&PermissionManageChannels == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	// Moving into a category also requires managing that category
+	if req.Channel.ParentID != current.ParentID && req.Channel.ParentID != 0 {
+		parentPerms := tx.GetPermissionsByChannel(c.userID, req.Channel.ParentID)
+		if parentPerms&PermissionManageChannels == 0 {
+			tx.Commit(nil)
+			c.HandleError(NewError(ErrorCodeNoPermission, nil))
+			return
 		}
-		embed.ID = snowflake.New()
+	}
 
-		err = tx.AddEmbed(id, embed)
+	channel := req.Channel
+	if _, err := tx.IsChannelValid(current.ID, current.Type, channel.Name, channel.Description, channel.ParentID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.UpdateChannel(current.ID, channel.Name, channel.Description, channel.Position, channel.ParentID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	channel, err = tx.GetChannel(current.ID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, channel.ID, AuditLogActionChannelUpdate, current, channel, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	index.UpdateChannel(channel)
+
+	gw.OnChannelUpdate(
+		&ChannelUpdateEvent{
+			Channel: channel,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleChannelReorderRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ChannelReorderRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	for _, position := range req.Positions {
+		current, err := tx.GetChannel(position.ChannelID)
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+
+		perms := tx.GetPermissionsByChannel(c.userID, position.ChannelID)
+		if perms&PermissionManageChannels == 0 {
+			err := NewError(ErrorCodeNoPermission, nil)
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+
+		if err := tx.SetChannelPosition(position.ChannelID, position.Position); err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+
+		before := ChannelPosition{ChannelID: current.ID, Position: current.Position}
+		if err := tx.AddAuditLogEntry(c.userID, current.ID, AuditLogActionChannelReorder, before, position, ""); err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	}
+
+	channels := make([]Channel, 0, len(req.Positions))
+	for _, position := range req.Positions {
+		channel, err := tx.GetChannel(position.ChannelID)
 		if err != nil {
 			tx.Commit(err)
 			c.HandleError(err)
 			return
 		}
+		channels = append(channels, channel)
 	}
-	message, err := tx.GetMessage(id)
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	for _, channel := range channels {
+		index.UpdateChannel(channel)
+
+		gw.OnChannelUpdate(
+			&ChannelUpdateEvent{
+				Channel: channel,
+			},
+		)
+	}
+}
+
+func (c *GatewayConnection) HandleChannelDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ChannelDeleteRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	channel, err := tx.GetChannel(req.ChannelID)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
+	perms := tx.GetPermissionsByChannel(c.userID, channel.ID)
+	if perms&PermissionManageChannels == 0 {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		return
+	}
+
+	if err := tx.DeleteChannel(channel.ID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if err := tx.AddAuditLogEntry(c.userID, channel.ID, AuditLogActionChannelDelete, channel, nil, ""); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	index := gw.GetIndex()
+
+	// Children of a deleted category lose their parent (ON DELETE SET NULL), threads are deleted with it
+	children := []Channel{}
+	threads := []Channel{}
+	for _, other := range index.GetAllChannels() {
+		if other.ParentID != channel.ID {
+			continue
+		}
+		if other.Type == ChannelTypeThread {
+			threads = append(threads, other)
+			continue
+		}
+		child, err := tx.GetChannel(other.ID)
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+		children = append(children, child)
+	}
+
 	tx.Commit(nil)
 
-	gw.OnMessageUpdate(&MessageUpdateEvent{
-		Message: message,
-	})
+	index.DeleteChannel(channel.ID)
+
+	gw.OnChannelDelete(
+		&ChannelDeleteEvent{
+			ChannelID: channel.ID,
+		},
+		channel,
+	)
+
+	for _, thread := range threads {
+		index.DeleteChannel(thread.ID)
+
+		// Relay against the parent, as the thread's own access check needs it in the index
+		gw.OnChannelDelete(
+			&ChannelDeleteEvent{
+				ChannelID: thread.ID,
+			},
+			channel,
+		)
+	}
+
+	for _, child := range children {
+		index.UpdateChannel(child)
+
+		gw.OnChannelUpdate(
+			&ChannelUpdateEvent{
+				Channel: child,
+			},
+		)
+	}
 }
 
-// Role Management Handlers
-func (c *GatewayConnection) HandleRoleAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req RoleAddRequest
+// Private Channel Handlers
+func (c *GatewayConnection) HandleDMOpenRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req DMOpenRequest
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
+	if req.UserID == c.userID {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
 	tx := storage.NewTransaction(db)
 	tx.Start()
-	perms, _ := tx.GetPermissionsByUser(c.userID)
-	if perms&PermissionManageRoles == 0 {
-		tx.Commit(nil)
-		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+
+	if _, err := tx.GetUser(req.UserID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
 		return
 	}
 
-	roleID, err := tx.AddRole(req.Name, req.Color, req.Position, req.Permissions, req.Hoisted, req.Mentionable)
+	channelID, err := tx.GetDMChannel(c.userID, req.UserID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	created := channelID == 0
+	if created {
+		channelID, err = tx.AddPrivateChannel(ChannelTypeDM, "", []Snowflake{c.userID, req.UserID})
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	}
+
+	channel, err := tx.GetChannel(channelID)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	role, err := tx.GetRole(roleID)
+	tx.Commit(nil)
+
+	if created {
+		index := gw.GetIndex()
+		index.AddChannel(channel)
+
+		gw.OnChannelAdd(
+			&ChannelAddEvent{
+				Channel: channel,
+			},
+		)
+	}
+
+	c.Write(Event{
+		Type: EventTypeDMOpenResponse,
+		Data: DMOpenResponse{
+			Channel: channel,
+		},
+	})
+}
+
+func (c *GatewayConnection) HandleGroupDMAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req GroupDMAddRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	var before Channel
+	members := []Snowflake{c.userID}
+
+	if req.ChannelID != 0 {
+		var err error
+		before, err = tx.GetChannel(req.ChannelID)
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+
+		if before.Type != ChannelTypeGroupDM || !before.HasRecipient(c.userID) {
+			tx.Commit(nil)
+			c.HandleError(NewError(ErrorCodeNoPermission, nil))
+			return
+		}
+
+		members = before.Recipients
+	}
+
+	added := []Snowflake{}
+	for _, userID := range req.UserIDs {
+		if slices.Contains(members, userID) || slices.Contains(added, userID) {
+			continue
+		}
+		if _, err := tx.GetUser(userID); err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+		added = append(added, userID)
+	}
+
+	if len(added) == 0 || len(members)+len(added) > GroupDMMaxMembers {
+		tx.Commit(nil)
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	channelID := req.ChannelID
+	if channelID == 0 {
+		var err error
+		channelID, err = tx.AddPrivateChannel(ChannelTypeGroupDM, req.Name, append(members, added...))
+		if err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+	} else {
+		for _, userID := range added {
+			if err := tx.AddChannelMember(channelID, userID); err != nil {
+				tx.Commit(err)
+				c.HandleError(err)
+				return
+			}
+		}
+	}
+
+	channel, err := tx.GetChannel(channelID)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
@@ -936,17 +3177,24 @@ func (c *GatewayConnection) HandleRoleAddRequest(msg *UnknownEvent, db *sqlite.C
 	tx.Commit(nil)
 
 	index := gw.GetIndex()
-	index.AddRole(role)
 
-	gw.OnRoleAdd(
-		&RoleAddEvent{
-			Role: role,
-		},
-	)
+	if req.ChannelID == 0 {
+		index.AddChannel(channel)
+
+		gw.OnChannelAdd(
+			&ChannelAddEvent{
+				Channel: channel,
+			},
+		)
+	} else {
+		index.UpdateChannel(channel)
+
+		gw.OnChannelAccessUpdate(before, channel)
+	}
 }
 
-func (c *GatewayConnection) HandleRoleUpdateRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req RoleUpdateRequest
+func (c *GatewayConnection) HandleGroupDMLeaveRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req GroupDMLeaveRequest
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
@@ -954,21 +3202,49 @@ func (c *GatewayConnection) HandleRoleUpdateRequest(msg *UnknownEvent, db *sqlit
 
 	tx := storage.NewTransaction(db)
 	tx.Start()
-	perms, _ := tx.GetPermissionsByUser(c.userID)
-	if perms&PermissionManageRoles == 0 {
+
+	before, err := tx.GetChannel(req.ChannelID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	if before.Type != ChannelTypeGroupDM || !before.HasRecipient(c.userID) {
 		tx.Commit(nil)
-		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
-	role := req.Role
-	if err := tx.UpdateRole(role.ID, role.Name, role.Color, role.Position, role.Permissions, role.Hoisted, role.Mentionable); err != nil {
+	if err := tx.DeleteChannelMember(before.ID, c.userID); err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	role, err := tx.GetRole(role.ID)
+	// The last member out deletes the group
+	if len(before.Recipients) == 1 {
+		if err := tx.DeleteChannel(before.ID); err != nil {
+			tx.Commit(err)
+			c.HandleError(err)
+			return
+		}
+
+		tx.Commit(nil)
+
+		index := gw.GetIndex()
+		index.DeleteChannel(before.ID)
+
+		gw.OnChannelDelete(
+			&ChannelDeleteEvent{
+				ChannelID: before.ID,
+			},
+			before,
+		)
+		return
+	}
+
+	channel, err := tx.GetChannel(before.ID)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
@@ -978,17 +3254,14 @@ func (c *GatewayConnection) HandleRoleUpdateRequest(msg *UnknownEvent, db *sqlit
 	tx.Commit(nil)
 
 	index := gw.GetIndex()
-	index.UpdateRole(role)
+	index.UpdateChannel(channel)
 
-	gw.OnRoleUpdate(
-		&RoleUpdateEvent{
-			Role: role,
-		},
-	)
+	gw.OnChannelAccessUpdate(before, channel)
 }
 
-func (c *GatewayConnection) HandleRoleDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req RoleDeleteRequest
+// Thread Handlers
+func (c *GatewayConnection) HandleThreadCreateRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ThreadCreateRequest
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
@@ -996,212 +3269,265 @@ func (c *GatewayConnection) HandleRoleDeleteRequest(msg *UnknownEvent, db *sqlit
 
 	tx := storage.NewTransaction(db)
 	tx.Start()
-	perms, _ := tx.GetPermissionsByUser(c.userID)
-	if perms&PermissionManageRoles == 0 {
-		tx.Commit(nil)
-		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+
+	starter, err := tx.GetMessage(req.MessageID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
 		return
 	}
 
-	if err := tx.DeleteRole(req.RoleID); err != nil {
+	parent, err := tx.GetChannel(starter.ChannelID)
+	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	tx.Commit(nil)
-
-	index := gw.GetIndex()
-	index.DeleteRole(req.RoleID)
-
-	gw.OnRoleDelete(
-		&RoleDeleteEvent{
-			RoleID: req.RoleID,
-		},
-	)
-}
-
-func (c *GatewayConnection) HandleUserRoleAddRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req UserRoleAddRequest
-	if err := json.Unmarshal(msg.Data, &req); err != nil {
+	if parent.Type != ChannelTypeText {
+		tx.Commit(nil)
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
-	tx := storage.NewTransaction(db)
-	tx.Start()
-
-	perms, _ := tx.GetPermissionsByUser(c.userID)
-	if perms&PermissionManageRoles == 0 {
+	perms := tx.GetPermissionsByChannel(c.userID, parent.ID)
+	if perms&PermissionViewChannel == 0 || perms&PermissionSendMessages == 0 {
 		tx.Commit(nil)
 		c.HandleError(NewError(ErrorCodeNoPermission, nil))
 		return
 	}
 
-	targetUser, err := tx.GetUser(req.UserID)
-	if err != nil {
+	if existing, err := tx.GetThreadByMessage(starter.ID); err != nil || existing != 0 {
+		if err == nil {
+			err = NewError(ErrorCodeInvalidRequest, fmt.Errorf("message already has a thread"))
+		}
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	role, err := tx.GetRole(req.RoleID)
+	threadID, err := tx.AddThread(parent.ID, starter.ID, req.Name)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	for _, roleID := range targetUser.Roles {
-		if roleID == role.ID {
-			tx.Commit(nil)
-			return
-		}
-	}
-
-	actorUser, err := tx.GetUser(c.userID)
+	thread, err := tx.GetChannel(threadID)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	roleCache := map[Snowflake]int{role.ID: role.Position}
-	actorRank, err := ComputeEffectiveRank(tx, actorUser, roleCache)
+	starter, err = tx.GetMessage(starter.ID)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	targetRank, err := ComputeEffectiveRank(tx, targetUser, roleCache)
-	if err != nil {
-		tx.Commit(err)
-		c.HandleError(err)
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	index.AddChannel(thread)
+
+	gw.OnChannelAdd(
+		&ChannelAddEvent{
+			Channel: thread,
+		},
+	)
+
+	gw.OnMessageUpdate(
+		&MessageUpdateEvent{
+			Message: starter,
+		},
+	)
+}
+
+func (c *GatewayConnection) HandleThreadsRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ThreadsRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
-	if req.UserID != c.userID && targetRank <= actorRank {
-		tx.Commit(nil)
+	index := gw.GetIndex()
+	perms := index.GetPermissionsByChannel(c.userID, req.ChannelID)
+	if perms&PermissionViewChannel == 0 {
 		c.HandleError(NewError(ErrorCodeNoPermission, nil))
 		return
 	}
 
-	if role.Position <= actorRank {
+	req.Limit = ClampInt(req.Limit, 1, 100)
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	threads, err := tx.GetThreads(req.ChannelID, req.Archived, req.Before, req.Limit)
+	if err != nil {
 		tx.Commit(nil)
-		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+		c.HandleError(err)
 		return
 	}
 
-	if err := tx.AddRoleToUser(req.UserID, req.RoleID); err != nil {
-		tx.Commit(err)
-		c.HandleError(err)
-		return
+	tx.Commit(nil)
+
+	c.Write(Event{
+		Type: EventTypeThreadsResponse,
+		Data: ThreadsResponse{
+			ChannelID: req.ChannelID,
+			Archived:  req.Archived,
+			Before:    req.Before,
+			Limit:     req.Limit,
+			Threads:   threads,
+		},
+	})
+}
+
+// Checks the caller may edit overwrites for the target role or user on a channel, returns their channel permissions
+func (c *GatewayConnection) CheckOverwritePermissions(tx *storage.Transaction, channelID Snowflake, overwriteType int, targetID Snowflake) (int, error) {
+	if _, err := tx.GetChannel(channelID); err != nil {
+		return 0, err
 	}
 
-	updatedUser, err := tx.GetUser(req.UserID)
+	perms := tx.GetPermissionsByChannel(c.userID, channelID)
+	if perms&PermissionManageChannels == 0 || perms&PermissionManageRoles == 0 {
+		return 0, NewError(ErrorCodeNoPermission, nil)
+	}
+
+	actorUser, err := tx.GetUser(c.userID)
 	if err != nil {
-		tx.Commit(err)
-		c.HandleError(err)
-		return
+		return 0, err
 	}
 
-	tx.Commit(nil)
+	roleCache := map[Snowflake]int{}
+	actorRank, err := ComputeEffectiveRank(tx, actorUser, roleCache)
+	if err != nil {
+		return 0, err
+	}
 
-	index := gw.GetIndex()
-	updatedUser = index.UpdateUser(updatedUser)
+	switch overwriteType {
+	case OverwriteTypeRole:
+		role, err := tx.GetRole(targetID)
+		if err != nil {
+			return 0, err
+		}
+		if role.Position <= actorRank {
+			return 0, NewError(ErrorCodeNoPermission, nil)
+		}
+	case OverwriteTypeUser:
+		targetUser, err := tx.GetUser(targetID)
+		if err != nil {
+			return 0, err
+		}
+		targetRank, err := ComputeEffectiveRank(tx, targetUser, roleCache)
+		if err != nil {
+			return 0, err
+		}
+		if targetUser.ID != c.userID && targetRank <= actorRank {
+			return 0, NewError(ErrorCodeNoPermission, nil)
+		}
+	default:
+		return 0, NewError(ErrorCodeInvalidRequest, nil)
+	}
 
-	gw.OnUserUpdate(
-		&UserUpdateEvent{User: updatedUser},
-	)
+	return perms, nil
 }
 
-func (c *GatewayConnection) HandleUserRoleDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
-	var req UserRoleDeleteRequest
+func (c *GatewayConnection) HandleChannelOverwriteSetRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ChannelOverwriteSetRequest
 	if err := json.Unmarshal(msg.Data, &req); err != nil {
 		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
 		return
 	}
 
 	tx := storage.NewTransaction(db)
-	tx.StartTimed("UserRoleDelete")
+	tx.Start()
 
-	perms, _ := tx.GetPermissionsByUser(c.userID)
-	if perms&PermissionManageRoles == 0 {
+	overwrite := req.Overwrite
+	perms, err := c.CheckOverwritePermissions(tx, req.ChannelID, overwrite.Type, overwrite.ID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
+		return
+	}
+
+	// Can't grant or revoke permissions the caller doesn't have
+	if (overwrite.Allow|overwrite.Deny)&^perms != 0 {
 		tx.Commit(nil)
 		c.HandleError(NewError(ErrorCodeNoPermission, nil))
 		return
 	}
 
-	targetUser, err := tx.GetUser(req.UserID)
+	current, err := tx.GetChannel(req.ChannelID)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	role, err := tx.GetRole(req.RoleID)
-	if err != nil {
+	if err := tx.SetChannelOverwrite(req.ChannelID, overwrite); err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	hasRole := false
-	for _, roleID := range targetUser.Roles {
-		if roleID == role.ID {
-			hasRole = true
-			break
-		}
-	}
-
-	if !hasRole {
-		tx.Commit(nil)
-		return
-	}
-
-	actorUser, err := tx.GetUser(c.userID)
+	channel, err := tx.GetChannel(req.ChannelID)
 	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	roleCache := map[Snowflake]int{role.ID: role.Position}
-	actorRank, err := ComputeEffectiveRank(tx, actorUser, roleCache)
-	if err != nil {
+	if err := tx.AddAuditLogEntry(c.userID, channel.ID, AuditLogActionChannelOverwriteSet, current.Overwrites, channel.Overwrites, ""); err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	targetRank, err := ComputeEffectiveRank(tx, targetUser, roleCache)
-	if err != nil {
+	tx.Commit(nil)
+
+	c.FinalizeChannelOverwriteRequest(channel)
+}
+
+func (c *GatewayConnection) HandleChannelOverwriteDeleteRequest(msg *UnknownEvent, db *sqlite.Conn) {
+	var req ChannelOverwriteDeleteRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		c.HandleError(NewError(ErrorCodeInvalidRequest, nil))
+		return
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+
+	if _, err := c.CheckOverwritePermissions(tx, req.ChannelID, req.Type, req.ID); err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	if req.UserID != c.userID && targetRank <= actorRank {
-		tx.Commit(nil)
-		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+	current, err := tx.GetChannel(req.ChannelID)
+	if err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
 		return
 	}
 
-	if role.Position <= actorRank {
-		tx.Commit(nil)
-		c.HandleError(NewError(ErrorCodeNoPermission, nil))
+	if err := tx.DeleteChannelOverwrite(req.ChannelID, req.Type, req.ID); err != nil {
+		tx.Commit(err)
+		c.HandleError(err)
 		return
 	}
 
-	if err := tx.DeleteRoleFromUser(req.UserID, req.RoleID); err != nil {
+	channel, err := tx.GetChannel(req.ChannelID)
+	if err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
 	}
 
-	updatedUser, err := tx.GetUser(req.UserID)
-	if err != nil {
+	if err := tx.AddAuditLogEntry(c.userID, channel.ID, AuditLogActionChannelOverwriteDelete, current.Overwrites, channel.Overwrites, ""); err != nil {
 		tx.Commit(err)
 		c.HandleError(err)
 		return
@@ -1209,36 +3535,19 @@ func (c *GatewayConnection) HandleUserRoleDeleteRequest(msg *UnknownEvent, db *s
 
 	tx.Commit(nil)
 
-	index := gw.GetIndex()
-	updatedUser = index.UpdateUser(updatedUser)
-
-	gw.OnUserUpdate(
-		&UserUpdateEvent{User: updatedUser},
-	)
+	c.FinalizeChannelOverwriteRequest(channel)
 }
 
-func ComputeEffectiveRank(tx *storage.Transaction, user User, cache map[Snowflake]int) (int, error) {
-	// Todo: Compute in user query
+func (c *GatewayConnection) FinalizeChannelOverwriteRequest(channel Channel) {
+	index := gw.GetIndex()
 
-	rank := math.MaxInt
-	for _, roleID := range user.Roles {
-		if pos, ok := cache[roleID]; ok {
-			if pos < rank {
-				rank = pos
-			}
-			continue
-		}
-		role, err := tx.GetRole(roleID)
-		if err != nil {
-			if cerr, ok := err.(*CodedError); ok && cerr.Code == ErrorCodeInvalidRequest {
-				continue
-			}
-			return 0, err
-		}
-		cache[roleID] = role.Position
-		if role.Position < rank {
-			rank = role.Position
-		}
+	before, ok := index.GetChannel(channel.ID)
+	if !ok {
+		before = channel
 	}
-	return rank, nil
+
+	// Update the index first so RelayByChannel stops delivering to anyone who lost access
+	index.UpdateChannel(channel)
+
+	gw.OnChannelAccessUpdate(before, channel)
 }
@@ -48,6 +48,7 @@ type Index struct {
 	UserInfos map[Snowflake]*UserInfo
 	Roles     map[Snowflake]Role
 	Channels  map[Snowflake]Channel
+	Silences  map[Snowflake]int
 	List      UserList
 	Settings  Settings
 
@@ -66,6 +67,7 @@ func (i *Index) PopulateIndex(conn *sqlite.Conn) {
 	i.Users = make(map[Snowflake]User)
 	i.Roles = make(map[Snowflake]Role)
 	i.Channels = make(map[Snowflake]Channel)
+	i.Silences = make(map[Snowflake]int)
 	i.UserInfos = make(map[Snowflake]*UserInfo)
 
 	startSettings := time.Now()
@@ -107,6 +109,12 @@ func (i *Index) PopulateIndex(conn *sqlite.Conn) {
 	}
 	gwLog.Printf("Index.PopulateIndex: users loaded in %v", time.Since(startUsers))
 
+	silences, err := tx.GetAllUserSilences()
+	if err != nil {
+		panic(err)
+	}
+	i.Silences = silences
+
 	tx.Commit(nil)
 	i.Mutex.Unlock()
 	gwLog.Printf("Index.PopulateIndex: total time %v", time.Since(startTotal))
@@ -376,7 +384,7 @@ func (i *Index) PopAllChanges() []IndexRange {
 	return changes
 }
 
-func (i *Index) GetUserListSlice(start, end, limit int) UserListResponse {
+func (i *Index) GetUserListSlice(viewerID Snowflake, start, end, limit int) UserListResponse {
 	i.Mutex.RLock()
 	defer i.Mutex.RUnlock()
 
@@ -388,23 +396,95 @@ func (i *Index) GetUserListSlice(start, end, limit int) UserListResponse {
 		end = start + limit
 	}
 
+	at, moved := i.selfOnlineView(viewerID)
+
 	resp := UserListResponse{
 		Start: start,
 		End:   end,
-		Slice: append([]Snowflake(nil), i.List.View[start:end]...),
+		Slice: make([]Snowflake, 0, end-start),
+	}
+	for idx := start; idx < end; idx++ {
+		resp.Slice = append(resp.Slice, at(idx))
 	}
 
 	resp.Groups = make([]UserListGroup, 0, len(i.List.GroupOrder))
 	for _, gid := range i.List.GroupOrder {
+		count := len(i.List.Groups[gid])
+		if moved && gid == Snowflake(UserPresenceOnline) {
+			count++
+		} else if moved && gid == Snowflake(UserPresenceOffline) {
+			count--
+		}
 		resp.Groups = append(resp.Groups, UserListGroup{
 			ID:    gid,
-			Count: len(i.List.Groups[gid]),
+			Count: count,
 		})
 	}
 
 	return resp
 }
 
+// The shared list puts invisible users under offline, in their own list they're moved into the online group
+func (i *Index) selfOnlineView(viewerID Snowflake) (func(idx int) Snowflake, bool) {
+	view := i.List.View
+	shared := func(idx int) Snowflake {
+		return view[idx]
+	}
+
+	user, ok := i.Users[viewerID]
+	if !ok || user.Presence != UserPresenceInvisible {
+		return shared, false
+	}
+
+	onlineID := Snowflake(UserPresenceOnline)
+	offlineID := Snowflake(UserPresenceOffline)
+
+	// Groups are laid out in order, each behind its header
+	onlineStart, offlineStart := -1, -1
+	offset := 0
+	for _, gid := range i.List.GroupOrder {
+		switch gid {
+		case onlineID:
+			onlineStart = offset
+		case offlineID:
+			offlineStart = offset
+		}
+		offset += 1 + len(i.List.Groups[gid])
+	}
+	if onlineStart < 0 || offlineStart < 0 {
+		return shared, false
+	}
+
+	pos := slices.Index(i.List.Groups[offlineID], viewerID)
+	if pos < 0 {
+		// Hoisted, the role group doesn't depend on presence
+		return shared, false
+	}
+	from := offlineStart + 1 + pos
+
+	// Same order as UpdateUserList, by display name then ID
+	online := i.List.Groups[onlineID]
+	into := sort.Search(len(online), func(j int) bool {
+		other := i.Users[online[j]]
+		if c := strings.Compare(user.DisplayName, other.DisplayName); c != 0 {
+			return c < 0
+		}
+		return viewerID < online[j]
+	})
+	to := onlineStart + 1 + into
+
+	return func(idx int) Snowflake {
+		switch {
+		case idx == to:
+			return viewerID
+		case idx > to && idx <= from:
+			return view[idx-1]
+		default:
+			return view[idx]
+		}
+	}, true
+}
+
 func (i *Index) computeUserInfo(u User) UserInfo {
 	rank := math.MaxInt
 	hoistRank := math.MaxInt
@@ -437,7 +517,13 @@ func (i *Index) computeUserInfo(u User) UserInfo {
 }
 
 func (i *Index) processUser(user User) User {
-	user.Presence = UserPresenceOffline
+	// Presence isn't stored, the gateway derives it from live connections
+	if user.Presence == UserPresenceNone {
+		user.Presence = UserPresenceOffline
+		if existing, ok := i.Users[user.ID]; ok {
+			user.Presence = existing.Presence
+		}
+	}
 	return user
 }
 
@@ -456,6 +542,17 @@ func (i *Index) GetUser(id Snowflake) (User, bool) {
 	return user, ok
 }
 
+func (i *Index) GetUserByName(name string) (User, bool) {
+	i.Mutex.RLock()
+	defer i.Mutex.RUnlock()
+	for _, user := range i.Users {
+		if strings.EqualFold(user.UserName, name) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
 func (i *Index) GetUsers(ids []Snowflake) []User {
 	i.Mutex.RLock()
 	defer i.Mutex.RUnlock()
@@ -542,6 +639,16 @@ func (i *Index) DeleteRole(id Snowflake) {
 	i.Stale = true
 }
 
+func (i *Index) SetSettings(settings Settings) {
+	i.Mutex.Lock()
+	i.Settings = settings
+	i.Stale = true
+	i.Mutex.Unlock()
+
+	// Default permissions feed into everyone's permissions
+	i.UpdateUserInfos()
+}
+
 func (i *Index) GetChannel(id Snowflake) (Channel, bool) {
 	i.Mutex.RLock()
 	defer i.Mutex.RUnlock()
@@ -559,6 +666,17 @@ func (i *Index) GetAllChannels() []Channel {
 	return channels
 }
 
+func (i *Index) GetChannelByName(name string) (Channel, bool) {
+	i.Mutex.RLock()
+	defer i.Mutex.RUnlock()
+	for _, channel := range i.Channels {
+		if channel.Type != ChannelTypeCategory && !channel.IsPrivate() && strings.EqualFold(channel.Name, name) {
+			return channel, true
+		}
+	}
+	return Channel{}, false
+}
+
 func (i *Index) AddChannel(channel Channel) {
 	i.Mutex.Lock()
 	defer i.Mutex.Unlock()
@@ -582,6 +700,24 @@ func (i *Index) DeleteChannel(id Snowflake) {
 	i.Stale = true
 }
 
+func (i *Index) IsUserSilenced(id Snowflake) bool {
+	i.Mutex.RLock()
+	defer i.Mutex.RUnlock()
+	expiresAt, ok := i.Silences[id]
+	return ok && int64(expiresAt) > time.Now().UnixMilli()
+}
+
+// Sets when a user's silence expires, 0 lifts it
+func (i *Index) SetUserSilence(id Snowflake, expiresAt int) {
+	i.Mutex.Lock()
+	defer i.Mutex.Unlock()
+	if expiresAt == 0 {
+		delete(i.Silences, id)
+	} else {
+		i.Silences[id] = expiresAt
+	}
+}
+
 func (i *Index) GetPermissionsByUser(userID Snowflake) int {
 	info, ok := i.UserInfos[userID]
 	if !ok || info == nil {
@@ -591,6 +727,40 @@ func (i *Index) GetPermissionsByUser(userID Snowflake) int {
 }
 
 func (i *Index) GetPermissionsByChannel(userID Snowflake, channelID Snowflake) int {
+	if channelID != 0 {
+		if channel, ok := i.GetChannel(channelID); ok {
+			return i.GetPermissionsByChannelState(userID, channel)
+		}
+	}
+
+	return i.GetPermissionsByOverwrites(userID, nil)
+}
+
+// Computes permissions against a given channel state, for channels that are not (or no longer) in the index
+func (i *Index) GetPermissionsByChannelState(userID Snowflake, channel Channel) int {
+	// Threads follow the channel they branch from
+	if channel.Type == ChannelTypeThread {
+		if channel.ParentID == 0 {
+			return 0
+		}
+		return i.GetPermissionsByChannel(userID, channel.ParentID)
+	}
+
+	// Membership is the only access check for private channels, even for administrators
+	if channel.IsPrivate() {
+		if !channel.HasRecipient(userID) {
+			return 0
+		}
+		if i.IsUserSilenced(userID) {
+			return PermissionPrivateChannel &^ PermissionSilenced
+		}
+		return PermissionPrivateChannel
+	}
+
+	return i.GetPermissionsByOverwrites(userID, channel.Overwrites)
+}
+
+func (i *Index) GetPermissionsByOverwrites(userID Snowflake, overwrites []Overwrite) int {
 	var allow int = i.GetPermissionsByUser(userID)
 	var deny int = 0
 	var user User
@@ -600,25 +770,20 @@ func (i *Index) GetPermissionsByChannel(userID Snowflake, channelID Snowflake) i
 		return 0
 	}
 
-	if channelID != 0 {
-		channel, ok := i.GetChannel(channelID)
-		if ok {
-			for _, overwrite := range channel.Overwrites {
-				if overwrite.Type == OverwriteTypeRole {
-					for _, roleID := range user.Roles {
-						if overwrite.ID == roleID {
-							allow |= overwrite.Allow
-							deny |= overwrite.Deny
-							break
-						}
-					}
-				} else if overwrite.Type == OverwriteTypeUser {
-					if overwrite.ID == user.ID {
-						allow |= overwrite.Allow
-						deny |= overwrite.Deny
-					}
+	for _, overwrite := range overwrites {
+		if overwrite.Type == OverwriteTypeRole {
+			for _, roleID := range user.Roles {
+				if overwrite.ID == roleID {
+					allow |= overwrite.Allow
+					deny |= overwrite.Deny
+					break
 				}
 			}
+		} else if overwrite.Type == OverwriteTypeUser {
+			if overwrite.ID == user.ID {
+				allow |= overwrite.Allow
+				deny |= overwrite.Deny
+			}
 		}
 	}
 
@@ -628,5 +793,9 @@ func (i *Index) GetPermissionsByChannel(userID Snowflake, channelID Snowflake) i
 		return PermissionAll
 	}
 
+	if i.IsUserSilenced(userID) {
+		permissions &^= PermissionSilenced
+	}
+
 	return permissions
 }
@@ -11,9 +11,16 @@ var (
 	roleMentionRegex    = regexp.MustCompile(`<@&([0-9]+)>`)
 	channelMentionRegex = regexp.MustCompile(`<#([0-9]+)>`)
 	urlRegex            = regexp.MustCompile(`(https?:\/\/[^\s<]+[^<.,:;"')\]\s])`)
+	everyoneRegex       = regexp.MustCompile(`@everyone\b`)
+	emojiNameRegex      = regexp.MustCompile(`^[A-Za-z0-9_]{2,32}$`)
+	emojiRegex          = regexp.MustCompile(`<(a?):([A-Za-z0-9_]{2,32}):([0-9]+)>`)
 )
 
-func ParseMessageContent(content string) (mentionedUsers []Snowflake, mentionedRoles []Snowflake, mentionedChannels []Snowflake, urls []string) {
+func ParseEveryoneMention(content string) bool {
+	return everyoneRegex.MatchString(content)
+}
+
+func ParseMessageContent(content string) (mentionedUsers []Snowflake, mentionedRoles []Snowflake, mentionedChannels []Snowflake, emojis []Emoji, urls []string) {
 	userMatches := userMentionRegex.FindAllStringSubmatch(content, -1)
 	for _, match := range userMatches {
 		if len(match) > 1 {
@@ -47,6 +54,24 @@ func ParseMessageContent(content string) (mentionedUsers []Snowflake, mentionedR
 		}
 	}
 
+	// Only the IDs are trusted, names are taken from the emojis table when stored
+	seenEmojis := map[Snowflake]bool{}
+	emojiMatches := emojiRegex.FindAllStringSubmatch(content, -1)
+	for _, match := range emojiMatches {
+		if len(match) > 3 {
+			emojiID, parseErr := strconv.ParseInt(match[3], 10, 64)
+			if parseErr != nil || seenEmojis[Snowflake(emojiID)] {
+				continue
+			}
+			seenEmojis[Snowflake(emojiID)] = true
+			emojis = append(emojis, Emoji{
+				ID:       Snowflake(emojiID),
+				Name:     match[2],
+				Animated: match[1] == "a",
+			})
+		}
+	}
+
 	urlMatches := urlRegex.FindAllStringSubmatch(content, -1)
 	for _, match := range urlMatches {
 		if len(match) > 1 {
@@ -54,5 +79,5 @@ func ParseMessageContent(content string) (mentionedUsers []Snowflake, mentionedR
 		}
 	}
 
-	return mentionedUsers, mentionedRoles, mentionedChannels, urls
+	return mentionedUsers, mentionedRoles, mentionedChannels, emojis, urls
 }
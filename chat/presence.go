@@ -0,0 +1,61 @@
+package chat
+
+import (
+	. "clack/common"
+)
+
+// A connection is live if it's attached and hasn't been closed
+func (c *GatewayConnection) Live() bool {
+	return c.Authenticated() && c.Connected() && !c.terminated.Load() && !c.Detached()
+}
+
+// Derives a user's presence from their live connections, a sticky presence wins while any are open
+func (gw *Gateway) ComputePresence(userID Snowflake, sticky int) int {
+	gw.connectionsMutex.RLock()
+	defer gw.connectionsMutex.RUnlock()
+
+	live, idle := 0, 0
+	for _, conn := range gw.userConnections[userID] {
+		if !conn.Live() {
+			continue
+		}
+		live++
+		if conn.idleSince.Load() != 0 {
+			idle++
+		}
+	}
+
+	if live == 0 {
+		return UserPresenceOffline
+	}
+	if sticky != UserPresenceNone {
+		return sticky
+	}
+	if idle == live {
+		return UserPresenceAway
+	}
+	return UserPresenceOnline
+}
+
+// Recomputes a user's presence and tells everyone if it changed
+func (gw *Gateway) UpdatePresence(userID Snowflake) {
+	index := gw.GetIndex()
+	user, ok := index.GetUser(userID)
+	if !ok {
+		return
+	}
+
+	presence := gw.ComputePresence(userID, user.PresenceSticky)
+	if presence == user.Presence {
+		return
+	}
+
+	user.Presence = presence
+	user = index.UpdateUser(user)
+
+	gw.OnUserUpdate(
+		&UserUpdateEvent{
+			User: user,
+		},
+	)
+}
@@ -34,12 +34,102 @@ func (gw *Gateway) RelayByChannel(event Event, channelID Snowflake) {
 	}()
 }
 
+func (gw *Gateway) RelayByChannelState(event Event, channel Channel) {
+	go func() {
+		index := gw.GetIndex()
+
+		gw.connectionsMutex.RLock()
+		defer gw.connectionsMutex.RUnlock()
+
+		for _, conn := range gw.connections {
+			if !conn.Authenticated() {
+				continue
+			}
+
+			perms := index.GetPermissionsByChannelState(conn.userID, channel)
+			if perms&PermissionViewChannel == 0 {
+				continue
+			}
+
+			conn.Relay(&event)
+		}
+	}()
+}
+
+func (gw *Gateway) RelayByUser(event Event, userID Snowflake) {
+	go func() {
+		gw.connectionsMutex.RLock()
+		defer gw.connectionsMutex.RUnlock()
+
+		for _, conn := range gw.connections {
+			if conn.userID != userID || !conn.Authenticated() {
+				continue
+			}
+
+			conn.Relay(&event)
+		}
+	}()
+}
+
+// Like RelayByChannel, but the given user's own connections get the personal event instead
+func (gw *Gateway) RelayByChannelWithPersonal(event Event, personal Event, userID Snowflake, channelID Snowflake) {
+	go func() {
+		index := gw.GetIndex()
+
+		gw.connectionsMutex.RLock()
+		defer gw.connectionsMutex.RUnlock()
+
+		for _, conn := range gw.connections {
+			if !conn.Authenticated() {
+				continue
+			}
+
+			if conn.userID == userID {
+				conn.Relay(&personal)
+				continue
+			}
+
+			perms := index.GetPermissionsByChannel(conn.userID, channelID)
+			if perms&PermissionViewChannel == 0 {
+				continue
+			}
+
+			conn.Relay(&event)
+		}
+	}()
+}
+
+// Like Relay, but the given user's own connections get the personal event instead
+func (gw *Gateway) RelayWithPersonal(event Event, personal Event, userID Snowflake) {
+	go func() {
+		gw.connectionsMutex.RLock()
+		defer gw.connectionsMutex.RUnlock()
+		for _, conn := range gw.connections {
+			if conn.userID == userID {
+				conn.Relay(&personal)
+			} else {
+				conn.Relay(&event)
+			}
+		}
+	}()
+}
+
 func (gw *Gateway) OnMessageAdd(msg *MessageAddEvent) {
 	event := Event{
 		Type: EventTypeMessageAdd,
 		Data: msg,
 	}
 
+	if msg.Author.Presence == UserPresenceInvisible {
+		public := *msg
+		public.Author = msg.Author.AsSeenBy(0)
+		gw.RelayByChannelWithPersonal(
+			Event{Type: EventTypeMessageAdd, Data: &public},
+			event, msg.Author.ID, msg.Message.ChannelID,
+		)
+		return
+	}
+
 	gw.RelayByChannel(event, msg.Message.ChannelID)
 }
 
@@ -52,6 +142,15 @@ func (gw *Gateway) OnMessageDelete(msg *MessageDeleteEvent, channelID Snowflake)
 	gw.RelayByChannel(event, channelID)
 }
 
+func (gw *Gateway) OnMessageDeleteBulk(msg *MessageDeleteBulkEvent) {
+	event := Event{
+		Type: EventTypeMessageDeleteBulk,
+		Data: msg,
+	}
+
+	gw.RelayByChannel(event, msg.ChannelID)
+}
+
 func (gw *Gateway) OnMessageUpdate(msg *MessageUpdateEvent) {
 	event := Event{
 		Type: EventTypeMessageUpdate,
@@ -61,6 +160,15 @@ func (gw *Gateway) OnMessageUpdate(msg *MessageUpdateEvent) {
 	gw.RelayByChannel(event, msg.Message.ChannelID)
 }
 
+func (gw *Gateway) OnChannelPinsUpdate(msg *ChannelPinsUpdateEvent) {
+	event := Event{
+		Type: EventTypeChannelPinsUpdate,
+		Data: msg,
+	}
+
+	gw.RelayByChannel(event, msg.ChannelID)
+}
+
 func (gw *Gateway) OnReactionAdd(msg *ReactionAddEvent, channelID Snowflake) {
 	event := Event{
 		Type: EventTypeMessageReactionAdd,
@@ -79,6 +187,33 @@ func (gw *Gateway) OnReactionDelete(msg *ReactionDeleteEvent, channelID Snowflak
 	gw.RelayByChannel(event, channelID)
 }
 
+func (gw *Gateway) OnReactionDeleteAll(msg *ReactionDeleteAllEvent, channelID Snowflake) {
+	event := Event{
+		Type: EventTypeMessageReactionDeleteAll,
+		Data: msg,
+	}
+
+	gw.RelayByChannel(event, channelID)
+}
+
+func (gw *Gateway) OnReactionDeleteEmoji(msg *ReactionDeleteEmojiEvent, channelID Snowflake) {
+	event := Event{
+		Type: EventTypeMessageReactionDeleteEmoji,
+		Data: msg,
+	}
+
+	gw.RelayByChannel(event, channelID)
+}
+
+func (gw *Gateway) OnUserTyping(msg *UserTypingEvent) {
+	event := Event{
+		Type: EventTypeUserTyping,
+		Data: msg,
+	}
+
+	gw.RelayByChannel(event, msg.ChannelID)
+}
+
 func (gw *Gateway) OnUserAdd(msg *UserAddEvent) {
 	event := Event{
 		Type: EventTypeUserAdd,
@@ -103,6 +238,14 @@ func (gw *Gateway) OnUserUpdate(msg *UserUpdateEvent) {
 		Data: msg,
 	}
 
+	if msg.User.Presence == UserPresenceInvisible {
+		gw.RelayWithPersonal(
+			Event{Type: EventTypeUserUpdate, Data: &UserUpdateEvent{User: msg.User.AsSeenBy(0)}},
+			event, msg.User.ID,
+		)
+		return
+	}
+
 	gw.Relay(event)
 }
 
@@ -132,3 +275,132 @@ func (gw *Gateway) OnRoleUpdate(msg *RoleUpdateEvent) {
 
 	gw.Relay(event)
 }
+
+func (gw *Gateway) OnChannelAdd(msg *ChannelAddEvent) {
+	event := Event{
+		Type: EventTypeChannelAdd,
+		Data: msg,
+	}
+
+	gw.RelayByChannel(event, msg.Channel.ID)
+}
+
+func (gw *Gateway) OnChannelUpdate(msg *ChannelUpdateEvent) {
+	event := Event{
+		Type: EventTypeChannelUpdate,
+		Data: msg,
+	}
+
+	gw.RelayByChannel(event, msg.Channel.ID)
+}
+
+func (gw *Gateway) OnChannelDelete(msg *ChannelDeleteEvent, channel Channel) {
+	event := Event{
+		Type: EventTypeChannelDelete,
+		Data: msg,
+	}
+
+	// The channel is already gone from the index, so check against its last known state
+	gw.RelayByChannelState(event, channel)
+}
+
+// Relays a change in who can see a channel (overwrites or members), adding or removing it for connections as needed
+func (gw *Gateway) OnChannelAccessUpdate(before Channel, after Channel) {
+	go func() {
+		index := gw.GetIndex()
+
+		update := Event{
+			Type: EventTypeChannelUpdate,
+			Data: &ChannelUpdateEvent{Channel: after},
+		}
+		add := Event{
+			Type: EventTypeChannelAdd,
+			Data: &ChannelAddEvent{Channel: after},
+		}
+		remove := Event{
+			Type: EventTypeChannelDelete,
+			Data: &ChannelDeleteEvent{ChannelID: after.ID},
+		}
+
+		gw.connectionsMutex.RLock()
+		defer gw.connectionsMutex.RUnlock()
+
+		for _, conn := range gw.connections {
+			if !conn.Authenticated() {
+				continue
+			}
+
+			could := index.GetPermissionsByChannelState(conn.userID, before)&PermissionViewChannel != 0
+			can := index.GetPermissionsByChannel(conn.userID, after.ID)&PermissionViewChannel != 0
+
+			if could && can {
+				conn.Relay(&update)
+			} else if could {
+				conn.Relay(&remove)
+			} else if can {
+				conn.Relay(&add)
+			}
+		}
+	}()
+}
+
+func (gw *Gateway) OnReadStateUpdate(msg *ReadStateUpdateEvent, userID Snowflake) {
+	event := Event{
+		Type: EventTypeReadStateUpdate,
+		Data: msg,
+	}
+
+	// Keeps the user's other connections in sync
+	gw.RelayByUser(event, userID)
+}
+
+func (gw *Gateway) OnEmojiAdd(msg *EmojiAddEvent) {
+	event := Event{
+		Type: EventTypeEmojiAdd,
+		Data: msg,
+	}
+
+	gw.Relay(event)
+}
+
+func (gw *Gateway) OnEmojiUpdate(msg *EmojiUpdateEvent) {
+	event := Event{
+		Type: EventTypeEmojiUpdate,
+		Data: msg,
+	}
+
+	gw.Relay(event)
+}
+
+func (gw *Gateway) OnEmojiDelete(msg *EmojiDeleteEvent) {
+	event := Event{
+		Type: EventTypeEmojiDelete,
+		Data: msg,
+	}
+
+	gw.Relay(event)
+}
+
+func (gw *Gateway) OnSettingsUpdate(settings Settings) {
+	go func() {
+		gw.connectionsMutex.RLock()
+		defer gw.connectionsMutex.RUnlock()
+
+		event := Event{
+			Type: EventTypeSettingsResponse,
+			Data: NewSettingsResponse(settings, true),
+		}
+		for _, conn := range gw.connections {
+			conn.Relay(&event)
+		}
+
+		// Guests need it too, the login screen shows the site name and captcha
+		guest := Event{
+			Type: EventTypeSettingsResponse,
+			Data: NewSettingsResponse(settings, false),
+		}
+		for conn := range gw.guests {
+			conn.WriteUnsolicited(guest)
+		}
+	}()
+}
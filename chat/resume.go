@@ -0,0 +1,185 @@
+package chat
+
+import (
+	. "clack/common"
+	"clack/storage"
+	"sync"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+const ResumeBufferSize = 256
+const ResumeGracePeriod = time.Minute * 2
+const ResumeExpiryInterval = time.Second * 10
+
+type ResumeRequest struct {
+	Session  string
+	Sequence int64
+}
+
+// Ring buffer of the events sent to a session, kept so a reconnecting client can catch up
+type EventBuffer struct {
+	events   []Event
+	sequence int64
+	mutex    sync.Mutex
+}
+
+func NewEventBuffer(size int) *EventBuffer {
+	return &EventBuffer{
+		events: make([]Event, size),
+	}
+}
+
+// Stamps the event with the next sequence number and keeps it for replay
+func (b *EventBuffer) Push(event Event) Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.sequence++
+	event.Sequence = b.sequence
+	b.events[b.sequence%int64(len(b.events))] = event
+
+	return event
+}
+
+// Returns the events after the given sequence number, fails if any of them were overwritten
+func (b *EventBuffer) Since(sequence int64) ([]Event, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if sequence < 0 || sequence > b.sequence || b.sequence-sequence > int64(len(b.events)) {
+		return nil, false
+	}
+
+	events := make([]Event, 0, b.sequence-sequence)
+	for s := sequence + 1; s <= b.sequence; s++ {
+		events = append(events, b.events[s%int64(len(b.events))])
+	}
+
+	return events, true
+}
+
+func (b *EventBuffer) Sequence() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.sequence
+}
+
+// Keeps a dropped connection around for ResumeGracePeriod, still buffering events, unless it was closed on purpose
+func (gw *Gateway) DetachConnection(c *GatewayConnection) {
+	if c.Authenticated() {
+		// Runs once the lock is released
+		defer gw.UpdatePresence(c.userID)
+	}
+
+	gw.connectionsMutex.Lock()
+	defer gw.connectionsMutex.Unlock()
+
+	if gw.connections[c.session] != c {
+		// Taken over by a resumed connection
+		return
+	}
+
+	gw.detachConnection(c)
+
+	if !c.Authenticated() || c.terminated.Load() || c.ctx.Err() != nil {
+		delete(gw.connections, c.session)
+		return
+	}
+
+	c.closing.Store(true)
+	c.detachedAt.Store(time.Now().UnixNano())
+}
+
+// Hands a previous session over to a new connection, returning the events the client missed
+func (gw *Gateway) ResumeConnection(c *GatewayConnection, userID Snowflake, token string, resume ResumeRequest) ([]Event, bool) {
+	gw.connectionsMutex.Lock()
+	defer gw.connectionsMutex.Unlock()
+
+	old, ok := gw.connections[resume.Session]
+	if !ok || old.terminated.Load() || old.userID != userID || old.token != token {
+		return nil, false
+	}
+
+	events, ok := old.buffer.Since(resume.Sequence)
+	if !ok {
+		// Overrun, the client needs a full overview anyway
+		delete(gw.connections, resume.Session)
+		return nil, false
+	}
+
+	if old.Connected() {
+		// The old socket hasn't noticed it's dead yet
+		old.closing.Store(true)
+		old.ws.Close()
+	}
+	old.terminated.Store(true)
+	gw.detachConnection(old)
+
+	c.userID = old.userID
+	c.token = old.token
+	c.session = old.session
+	c.buffer = old.buffer
+	c.lastUserListRange = old.lastUserListRange
+
+	delete(gw.guests, c)
+	gw.connections[c.session] = c
+	gw.attachConnection(c)
+
+	return events, true
+}
+
+// Drops detached connections whose grace period has passed
+func (gw *Gateway) ExpireConnections() {
+	gw.connectionsMutex.Lock()
+	defer gw.connectionsMutex.Unlock()
+
+	for session, conn := range gw.connections {
+		if !conn.Detached() {
+			continue
+		}
+		if conn.terminated.Load() || time.Since(time.Unix(0, conn.detachedAt.Load())) > ResumeGracePeriod {
+			delete(gw.connections, session)
+		}
+	}
+}
+
+func (c *GatewayConnection) TryResume(token string, resume ResumeRequest, db *sqlite.Conn) bool {
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	userID, err := tx.Authenticate(token, c.ClientIP())
+	tx.Commit(err)
+
+	if err != nil {
+		return false
+	}
+
+	events, ok := gw.ResumeConnection(c, userID, token, resume)
+	if !ok {
+		return false
+	}
+
+	gw.UpdatePresence(c.userID)
+
+	// Replayed events keep their original sequence numbers, the resume notice has none
+	c.WriteStamped(Event{
+		Type: EventTypeResumed,
+		Data: ResumedEvent{
+			Session:  c.session,
+			Sequence: resume.Sequence,
+			Replayed: len(events),
+		},
+	})
+
+	for _, event := range events {
+		c.WriteStamped(event)
+	}
+
+	// User list changes aren't buffered, so send the current state of whatever the client was looking at
+	if last := c.lastUserListRange; last.To > last.From {
+		c.NotifyUserList()
+	}
+
+	return true
+}
@@ -0,0 +1,184 @@
+package chat
+
+import (
+	. "clack/common"
+	"clack/storage"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SearchDateFormat = "2006-01-02"
+
+// Splits a search query on whitespace, keeping double quoted runs together (quotes are kept)
+func tokenizeSearchQuery(query string) []string {
+	tokens := []string{}
+	var current strings.Builder
+	quoted := false
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+
+	return tokens
+}
+
+func parseSearchID(value string, prefix string) (Snowflake, bool) {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, prefix), ">")
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return Snowflake(id), true
+}
+
+func resolveSearchUser(index *Index, value string) (Snowflake, error) {
+	if id, ok := parseSearchID(value, "<@"); ok {
+		return id, nil
+	}
+	if user, ok := index.GetUserByName(strings.TrimPrefix(value, "@")); ok {
+		return user.ID, nil
+	}
+	return 0, NewError(ErrorCodeInvalidRequest, fmt.Errorf("unknown user: %s", value))
+}
+
+func resolveSearchChannel(index *Index, value string) (Snowflake, error) {
+	if id, ok := parseSearchID(value, "<#"); ok {
+		return id, nil
+	}
+	if channel, ok := index.GetChannelByName(strings.TrimPrefix(value, "#")); ok {
+		return channel.ID, nil
+	}
+	return 0, NewError(ErrorCodeInvalidRequest, fmt.Errorf("unknown channel: %s", value))
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	date, err := time.Parse(SearchDateFormat, value)
+	if err != nil {
+		return time.Time{}, NewError(ErrorCodeInvalidRequest, fmt.Errorf("invalid date: %s", value))
+	}
+	return date, nil
+}
+
+// Quotes a term so FTS5 treats it literally, unquoted terms also match as a prefix
+func quoteSearchTerm(term string) string {
+	phrase := strings.HasPrefix(term, `"`) && strings.HasSuffix(term, `"`) && len(term) > 1
+	term = strings.Trim(term, `"`)
+	if term == "" {
+		return ""
+	}
+
+	quoted := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	if !phrase {
+		quoted += "*"
+	}
+	return quoted
+}
+
+// Parses a query like `from:user in:channel has:link before:2024-01-01 some words` into a search,
+// restricted to the channels the user can read
+func ParseSearchQuery(index *Index, userID Snowflake, query string) (storage.MessageSearch, error) {
+	search := storage.MessageSearch{}
+	terms := []string{}
+	channelID := Snowflake(0)
+	filtered := false
+
+	for _, token := range tokenizeSearchQuery(query) {
+		key, value, found := strings.Cut(token, ":")
+		value = strings.Trim(value, `"`)
+		if !found || value == "" {
+			if term := quoteSearchTerm(token); term != "" {
+				terms = append(terms, term)
+			}
+			continue
+		}
+
+		var err error
+		switch strings.ToLower(key) {
+		case "from":
+			search.AuthorID, err = resolveSearchUser(index, value)
+		case "mentions":
+			search.MentionsID, err = resolveSearchUser(index, value)
+		case "in":
+			channelID, err = resolveSearchChannel(index, value)
+		case "has":
+			switch strings.ToLower(value) {
+			case "attachment", "file":
+				search.HasAttachment = true
+			case "embed":
+				search.HasEmbed = true
+			case "link":
+				search.HasLink = true
+			default:
+				err = NewError(ErrorCodeInvalidRequest, fmt.Errorf("invalid has filter: %s", value))
+			}
+		case "before":
+			var date time.Time
+			if date, err = parseSearchDate(value); err == nil {
+				search.BeforeTime = int(date.UnixMilli())
+			}
+		case "after":
+			var date time.Time
+			if date, err = parseSearchDate(value); err == nil {
+				search.AfterTime = int(date.AddDate(0, 0, 1).UnixMilli())
+			}
+		case "pinned":
+			var pinned bool
+			if pinned, err = strconv.ParseBool(value); err != nil {
+				err = NewError(ErrorCodeInvalidRequest, fmt.Errorf("invalid pinned filter: %s", value))
+			}
+			search.Pinned = &pinned
+		default:
+			if term := quoteSearchTerm(token); term != "" {
+				terms = append(terms, term)
+			}
+			continue
+		}
+
+		if err != nil {
+			return search, err
+		}
+		filtered = true
+	}
+
+	if len(terms) == 0 && !filtered {
+		return search, NewError(ErrorCodeInvalidRequest, fmt.Errorf("empty search"))
+	}
+
+	search.Match = strings.Join(terms, " ")
+
+	canRead := func(id Snowflake) bool {
+		perms := index.GetPermissionsByChannel(userID, id)
+		return perms&PermissionViewChannel != 0 && perms&PermissionReadMessageHistory != 0
+	}
+
+	if channelID != 0 {
+		if !canRead(channelID) {
+			return search, NewError(ErrorCodeNoPermission, nil)
+		}
+		search.ChannelIDs = []Snowflake{channelID}
+	} else {
+		for _, channel := range index.GetAllChannels() {
+			if channel.Type != ChannelTypeCategory && canRead(channel.ID) {
+				search.ChannelIDs = append(search.ChannelIDs, channel.ID)
+			}
+		}
+	}
+
+	return search, nil
+}
@@ -0,0 +1,60 @@
+package chat
+
+import (
+	. "clack/common"
+	"clack/storage"
+	"time"
+)
+
+const ThreadArchiveAfter = time.Hour * 24
+const ThreadArchiveInterval = time.Minute
+
+// Archives threads with no messages for ThreadArchiveAfter, sending a message unarchives them
+func (gw *Gateway) ArchiveInactiveThreads() {
+	conn, err := storage.OpenConnection(gwCtx)
+	if err != nil {
+		gwLog.Printf("Failed to open connection: %v", err)
+		return
+	}
+	defer storage.CloseConnection(conn)
+
+	tx := storage.NewTransaction(conn)
+	tx.Start()
+
+	cutoff := int(time.Now().Add(-ThreadArchiveAfter).UnixMilli())
+	ids, err := tx.GetInactiveThreads(cutoff)
+	if err != nil || len(ids) == 0 {
+		tx.Commit(err)
+		return
+	}
+
+	threads := make([]Channel, 0, len(ids))
+	for _, id := range ids {
+		if err := tx.SetThreadArchived(id, true); err != nil {
+			tx.Commit(err)
+			gwLog.Printf("Failed to archive thread %d: %v", id, err)
+			return
+		}
+
+		thread, err := tx.GetChannel(id)
+		if err != nil {
+			tx.Commit(err)
+			gwLog.Printf("Failed to archive thread %d: %v", id, err)
+			return
+		}
+		threads = append(threads, thread)
+	}
+
+	tx.Commit(nil)
+
+	index := gw.GetIndex()
+	for _, thread := range threads {
+		index.UpdateChannel(thread)
+
+		gw.OnChannelUpdate(
+			&ChannelUpdateEvent{
+				Channel: thread,
+			},
+		)
+	}
+}
@@ -0,0 +1,109 @@
+package chat
+
+import (
+	. "clack/common"
+	"sync"
+	"time"
+)
+
+const TypingThrottle = time.Second * 5
+const TypingTimeout = time.Second * 10
+
+type typingKey struct {
+	UserID    Snowflake
+	ChannelID Snowflake
+}
+
+type typingEntry struct {
+	Relayed time.Time
+	Expires time.Time
+}
+
+type TypingIndex struct {
+	entries map[typingKey]*typingEntry
+	mutex   sync.Mutex
+}
+
+// Marks a user as typing, returns true if the start should be relayed (not throttled)
+func (t *TypingIndex) Start(userID Snowflake, channelID Snowflake) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	key := typingKey{UserID: userID, ChannelID: channelID}
+
+	entry, ok := t.entries[key]
+	if ok && now.Sub(entry.Relayed) < TypingThrottle {
+		entry.Expires = now.Add(TypingTimeout)
+		return false
+	}
+
+	t.entries[key] = &typingEntry{
+		Relayed: now,
+		Expires: now.Add(TypingTimeout),
+	}
+	return true
+}
+
+// Clears a user's typing state, returns true if they were typing
+func (t *TypingIndex) Stop(userID Snowflake, channelID Snowflake) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := typingKey{UserID: userID, ChannelID: channelID}
+	if _, ok := t.entries[key]; !ok {
+		return false
+	}
+	delete(t.entries, key)
+	return true
+}
+
+// Removes and returns all typing states that have timed out
+func (t *TypingIndex) PopExpired() []typingKey {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	expired := []typingKey{}
+	for key, entry := range t.entries {
+		if now.After(entry.Expires) {
+			expired = append(expired, key)
+			delete(t.entries, key)
+		}
+	}
+	return expired
+}
+
+func (gw *Gateway) StartTyping(userID Snowflake, channelID Snowflake) {
+	if !gw.typing.Start(userID, channelID) {
+		return
+	}
+
+	gw.OnUserTyping(&UserTypingEvent{
+		UserID:    userID,
+		ChannelID: channelID,
+		Typing:    true,
+	})
+}
+
+func (gw *Gateway) StopTyping(userID Snowflake, channelID Snowflake) {
+	if !gw.typing.Stop(userID, channelID) {
+		return
+	}
+
+	gw.OnUserTyping(&UserTypingEvent{
+		UserID:    userID,
+		ChannelID: channelID,
+		Typing:    false,
+	})
+}
+
+func (gw *Gateway) ExpireTyping() {
+	for _, key := range gw.typing.PopExpired() {
+		gw.OnUserTyping(&UserTypingEvent{
+			UserID:    key.UserID,
+			ChannelID: key.ChannelID,
+			Typing:    false,
+		})
+	}
+}
@@ -441,7 +441,7 @@ func TryServeUncached(ctx context.Context, w http.ResponseWriter, r *http.Reques
 func GetCacheRequest(messageID, embedID Snowflake, url string) cacheRequest {
 	hash := GetCacheHash(url)
 	lock := GetCacheLock(hash)
-	filePath := filepath.Join(DataFolder, "external", hash)
+	filePath := filepath.Join(Config.DataFolder, "external", hash)
 
 	return cacheRequest{
 		url:           url,
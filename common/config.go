@@ -0,0 +1,292 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const ConfigEnvPrefix = "CLACK_"
+const ConfigDefaultPath = "clack.yaml"
+
+type ServerConfig struct {
+	Listen           string `yaml:"listen"`
+	DataFolder       string `yaml:"data_folder"`
+	DistFolder       string `yaml:"dist_folder"`
+	DevProxy         string `yaml:"dev_proxy" redact:"url"`
+	MaxContentLength int64  `yaml:"max_content_length"`
+	PoolSize         int    `yaml:"pool_size"`
+	// Gateway pings, a connection that doesn't answer for the timeout is dropped
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	HeartbeatTimeout  time.Duration `yaml:"heartbeat_timeout"`
+	TokenExpiry       time.Duration `yaml:"token_expiry"` // Since last use, 0 to never expire
+	Sandbox           SandboxConfig `yaml:"sandbox"`
+	Admin             AdminConfig   `yaml:"admin"`
+}
+
+// Limits for the nsjail sandbox around ffmpeg, in nsjail's units
+type SandboxConfig struct {
+	AddressSpace int `yaml:"address_space"` // MiB
+	CPU          int `yaml:"cpu"`           // Seconds
+	Files        int `yaml:"files"`
+	Processes    int `yaml:"processes"`
+	Stack        int `yaml:"stack"`   // MiB
+	Memlock      int `yaml:"memlock"` // KiB
+}
+
+// Only used by the first-run setup, when the database has no users yet
+type AdminConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password" redact:"all"`
+}
+
+// The effective configuration, only changed by LoadConfig during startup
+var Config = DefaultConfig()
+
+func DefaultConfig() ServerConfig {
+	return ServerConfig{
+		Listen:           ":8000",
+		DataFolder:       "data",
+		DistFolder:       "/var/www/clack",
+		DevProxy:         "http://localhost:5173",
+		MaxContentLength: 1024 * 1024 * 64, // 64MB
+		PoolSize:         16,
+		Sandbox: SandboxConfig{
+			AddressSpace: 1024,
+			CPU:          10,
+			Files:        128,
+			Processes:    128,
+			Stack:        8,
+			Memlock:      64,
+		},
+		HeartbeatInterval: time.Second * 30,
+		HeartbeatTimeout:  time.Second * 75,
+		TokenExpiry:       time.Hour * 24 * 30,
+	}
+}
+
+// Builds the configuration from the defaults, then the config file, then CLACK_* variables, then flags
+func LoadConfig(args []string) (ServerConfig, error) {
+	config := DefaultConfig()
+	fields := config.fields()
+
+	flags := flag.NewFlagSet("clack", flag.ContinueOnError)
+	path := flags.String("config", "", "path to the config file (default "+ConfigDefaultPath+")")
+	values := map[string]*string{}
+	for _, field := range fields {
+		values[field.FlagName()] = flags.String(field.FlagName(), "", fmt.Sprintf("overrides %s (%s)", field.name, field.EnvName()))
+	}
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *path == "" {
+		*path = os.Getenv(ConfigEnvPrefix + "CONFIG")
+	}
+	if err := config.readFile(*path); err != nil {
+		return config, err
+	}
+
+	for _, field := range fields {
+		if value, ok := os.LookupEnv(field.EnvName()); ok {
+			if err := field.Set(value); err != nil {
+				return config, fmt.Errorf("%s: %v", field.EnvName(), err)
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, field := range fields {
+			if field.FlagName() == f.Name && flagErr == nil {
+				if err := field.Set(*values[f.Name]); err != nil {
+					flagErr = fmt.Errorf("-%s: %v", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return config, flagErr
+	}
+
+	return config, config.Validate()
+}
+
+// An explicit path must exist, the default one is optional
+func (c *ServerConfig) readFile(path string) error {
+	optional := path == ""
+	if optional {
+		path = ConfigDefaultPath
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && optional {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config %s: %v", path, err)
+	}
+
+	return nil
+}
+
+func (c *ServerConfig) Validate() error {
+	_, port, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return fmt.Errorf("listen: %v", err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("listen: invalid port '%s'", port)
+	}
+
+	if c.DataFolder == "" {
+		return fmt.Errorf("data_folder: must not be empty")
+	}
+
+	if c.DevProxy != "" {
+		u, err := url.Parse(c.DevProxy)
+		if err != nil {
+			return fmt.Errorf("dev_proxy: %v", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("dev_proxy: '%s' must be an http(s) URL with a host", u.Redacted())
+		}
+	}
+
+	if c.MaxContentLength <= 0 {
+		return fmt.Errorf("max_content_length: must be positive")
+	}
+
+	if c.PoolSize < 1 || c.PoolSize > 256 {
+		return fmt.Errorf("pool_size: must be between 1 and 256")
+	}
+
+	for _, field := range collectConfigFields(reflect.ValueOf(&c.Sandbox).Elem(), "sandbox.") {
+		if field.value.Int() <= 0 {
+			return fmt.Errorf("%s: must be positive", field.name)
+		}
+	}
+
+	if c.HeartbeatInterval <= 0 {
+		return fmt.Errorf("heartbeat_interval: must be positive")
+	}
+	if c.HeartbeatTimeout <= c.HeartbeatInterval {
+		return fmt.Errorf("heartbeat_timeout: must be longer than heartbeat_interval")
+	}
+
+	if c.TokenExpiry < 0 {
+		return fmt.Errorf("token_expiry: must not be negative")
+	}
+
+	return nil
+}
+
+// The effective configuration as "name: value" lines, with secrets redacted
+func (c *ServerConfig) Describe() []string {
+	lines := []string{}
+	for _, field := range c.fields() {
+		lines = append(lines, fmt.Sprintf("%s: %s", field.name, field.String()))
+	}
+	return lines
+}
+
+type configField struct {
+	name   string
+	value  reflect.Value
+	redact string
+}
+
+func (c *ServerConfig) fields() []configField {
+	return collectConfigFields(reflect.ValueOf(c).Elem(), "")
+}
+
+// Flattens nested sections into dotted names, e.g. sandbox.cpu
+func collectConfigFields(v reflect.Value, prefix string) []configField {
+	fields := []configField{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := prefix + f.Tag.Get("yaml")
+		if f.Type.Kind() == reflect.Struct {
+			fields = append(fields, collectConfigFields(v.Field(i), name+".")...)
+			continue
+		}
+		fields = append(fields, configField{
+			name:   name,
+			value:  v.Field(i),
+			redact: f.Tag.Get("redact"),
+		})
+	}
+	return fields
+}
+
+func (f configField) EnvName() string {
+	return ConfigEnvPrefix + strings.ToUpper(strings.ReplaceAll(f.name, ".", "_"))
+}
+
+func (f configField) FlagName() string {
+	return strings.ReplaceAll(f.name, "_", "-")
+}
+
+func (f configField) Set(s string) error {
+	if f.value.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a duration", s)
+		}
+		f.value.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer", s)
+		}
+		f.value.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", s)
+		}
+		f.value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Kind())
+	}
+	return nil
+}
+
+func (f configField) String() string {
+	s := fmt.Sprint(f.value.Interface())
+	switch f.redact {
+	case "all":
+		if s != "" {
+			return "[redacted]"
+		}
+	case "url":
+		if u, err := url.Parse(s); err == nil {
+			return u.Redacted()
+		}
+	}
+	return s
+}
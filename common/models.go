@@ -19,6 +19,7 @@ const (
 	UserPresenceOnline       = iota
 	UserPresenceAway         = iota
 	UserPresenceDoNotDisturb = iota
+	UserPresenceInvisible    = iota
 )
 
 type User struct {
@@ -46,7 +47,15 @@ func (u User) MarshalJSON() ([]byte, error) {
 }
 
 func (u User) IsOnline() bool {
-	return u.Presence != UserPresenceOffline
+	return u.Presence != UserPresenceOffline && u.Presence != UserPresenceInvisible
+}
+
+// Invisible users appear offline to everyone except themselves
+func (u User) AsSeenBy(viewerID Snowflake) User {
+	if u.Presence == UserPresenceInvisible && u.ID != viewerID {
+		u.Presence = UserPresenceOffline
+	}
+	return u
 }
 
 const (
@@ -83,6 +92,11 @@ const PermissionDefault = PermissionSendMessages |
 
 const PermissionAll = 0x7FFFFFFF
 
+// Stripped from silenced users in every channel
+const PermissionSilenced = PermissionSendMessages |
+	PermissionAddReactions |
+	PermissionUploadFiles
+
 const (
 	OverwriteTypeRole = iota
 	OverwriteTypeUser = iota
@@ -99,8 +113,21 @@ const (
 	ChannelTypeText     = iota
 	ChannelTypeVoice    = iota
 	ChannelTypeCategory = iota
+	ChannelTypeDM       = iota
+	ChannelTypeGroupDM  = iota
+	ChannelTypeThread   = iota
 )
 
+// Granted to every member of a DM or group DM, private channels ignore roles and overwrites
+const PermissionPrivateChannel = PermissionSendMessages |
+	PermissionAddReactions |
+	PermissionEmbedLinks |
+	PermissionUploadFiles |
+	PermissionViewChannel |
+	PermissionReadMessageHistory
+
+const GroupDMMaxMembers = 10
+
 type Channel struct {
 	ID          Snowflake   `json:"id" validate:"required"`
 	Type        int         `json:"type" validate:"required"`
@@ -109,6 +136,32 @@ type Channel struct {
 	Position    int         `json:"position,omitempty"`
 	ParentID    Snowflake   `json:"parent,omitempty"`
 	Overwrites  []Overwrite `json:"overwrites,omitempty"`
+	Recipients  []Snowflake `json:"recipients,omitempty"`
+
+	// Threads
+	MessageID    Snowflake `json:"message,omitempty"`
+	Archived     bool      `json:"archived,omitempty"`
+	LastActivity int       `json:"lastActivity,omitempty"`
+}
+
+func (c Channel) IsPrivate() bool {
+	return c.Type == ChannelTypeDM || c.Type == ChannelTypeGroupDM
+}
+
+func (c Channel) HasRecipient(userID Snowflake) bool {
+	for _, id := range c.Recipients {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+type ThreadSummary struct {
+	ChannelID    Snowflake `json:"channel" validate:"required"`
+	ReplyCount   int       `json:"replyCount"`
+	LastActivity int       `json:"lastActivity" validate:"required"`
+	Archived     bool      `json:"archived,omitempty"`
 }
 
 type Role struct {
@@ -122,8 +175,10 @@ type Role struct {
 }
 
 type Emoji struct {
-	Name string    `json:"name" validate:"required"`
-	ID   Snowflake `json:"id,omitempty"`
+	Name       string    `json:"name" validate:"required"`
+	ID         Snowflake `json:"id,omitempty"`
+	Animated   bool      `json:"animated,omitempty"`
+	UploaderID Snowflake `json:"uploader,omitempty"`
 }
 
 const (
@@ -131,22 +186,25 @@ const (
 )
 
 type Message struct {
-	ID                Snowflake    `json:"id" validate:"required"`
-	Type              int          `json:"type" validate:"required"`
-	ChannelID         Snowflake    `json:"channel" validate:"required"`
-	Timestamp         int          `json:"timestamp" validate:"required"`
-	Pinned            bool         `json:"pinned,omitempty" validate:"required"`
-	AuthorID          Snowflake    `json:"author" validate:"required"`
-	ReferenceID       Snowflake    `json:"reference,omitempty"`
-	Content           string       `json:"content" validate:"required"`
-	EditedTimestamp   int          `json:"editedTimestamp,omitempty"`
-	Attachments       []Attachment `json:"attachments,omitempty"`
-	Embeds            []Embed      `json:"embeds,omitempty"`
-	Reactions         []Reaction   `json:"reactions,omitempty"`
-	MentionedUsers    []Snowflake  `json:"mentionedUsers,omitempty"`
-	MentionedRoles    []Snowflake  `json:"mentionedRoles,omitempty"`
-	MentionedChannels []Snowflake  `json:"mentionedChannels,omitempty"`
-	EmbeddableURLs    []string     `json:"embeddableURLs,omitempty"`
+	ID                Snowflake      `json:"id" validate:"required"`
+	Type              int            `json:"type" validate:"required"`
+	ChannelID         Snowflake      `json:"channel" validate:"required"`
+	Timestamp         int            `json:"timestamp" validate:"required"`
+	Pinned            bool           `json:"pinned,omitempty" validate:"required"`
+	AuthorID          Snowflake      `json:"author" validate:"required"`
+	ReferenceID       Snowflake      `json:"reference,omitempty"`
+	Content           string         `json:"content" validate:"required"`
+	EditedTimestamp   int            `json:"editedTimestamp,omitempty"`
+	Attachments       []Attachment   `json:"attachments,omitempty"`
+	Embeds            []Embed        `json:"embeds,omitempty"`
+	Reactions         []Reaction     `json:"reactions,omitempty"`
+	MentionedUsers    []Snowflake    `json:"mentionedUsers,omitempty"`
+	MentionedRoles    []Snowflake    `json:"mentionedRoles,omitempty"`
+	MentionedChannels []Snowflake    `json:"mentionedChannels,omitempty"`
+	MentionsEveryone  bool           `json:"mentionsEveryone,omitempty"`
+	Emojis            []Emoji        `json:"emojis,omitempty"`
+	EmbeddableURLs    []string       `json:"embeddableURLs,omitempty"`
+	Thread            *ThreadSummary `json:"thread,omitempty"`
 }
 
 const (
@@ -227,6 +285,72 @@ type Attachment struct {
 	Height   int       `json:"height,omitempty"`
 }
 
+type Session struct {
+	ID         string `json:"id" validate:"required"`
+	CreatedAt  int    `json:"createdAt" validate:"required"`
+	LastUsedAt int    `json:"lastUsedAt" validate:"required"`
+	IP         string `json:"ip,omitempty"`
+	Current    bool   `json:"current,omitempty"`
+}
+
+type InviteCode struct {
+	Code        string    `json:"code" validate:"required"`
+	UserID      Snowflake `json:"user" validate:"required"`
+	CreatedAt   int       `json:"createdAt" validate:"required"`
+	ExpiresAt   int       `json:"expiresAt,omitempty"`
+	Uses        int       `json:"uses"`
+	MaxUses     int       `json:"maxUses,omitempty"`
+	Invalidated bool      `json:"invalidated,omitempty"`
+}
+
+const (
+	AuditLogActionSettingsUpdate         = iota
+	AuditLogActionRoleAdd                = iota
+	AuditLogActionRoleUpdate             = iota
+	AuditLogActionRoleDelete             = iota
+	AuditLogActionUserRoleAdd            = iota
+	AuditLogActionUserRoleDelete         = iota
+	AuditLogActionUserKick               = iota
+	AuditLogActionUserBan                = iota
+	AuditLogActionUserSilence            = iota
+	AuditLogActionMessageDelete          = iota
+	AuditLogActionChannelAdd             = iota
+	AuditLogActionChannelUpdate          = iota
+	AuditLogActionChannelDelete          = iota
+	AuditLogActionChannelOverwriteSet    = iota
+	AuditLogActionChannelOverwriteDelete = iota
+	AuditLogActionInviteCodeInvalidate   = iota
+	AuditLogActionEmojiAdd               = iota
+	AuditLogActionEmojiUpdate            = iota
+	AuditLogActionEmojiDelete            = iota
+	AuditLogActionMessageDeleteBulk      = iota
+	AuditLogActionReactionDelete         = iota
+	AuditLogActionChannelReorder         = iota
+	AuditLogActionMessagePin             = iota
+	AuditLogActionMessageUnpin           = iota
+	AuditLogActionReactionDeleteAll      = iota
+	AuditLogActionReactionDeleteEmoji    = iota
+)
+
+type AuditLogEntry struct {
+	ID        Snowflake       `json:"id" validate:"required"`
+	ActorID   Snowflake       `json:"actor" validate:"required"`
+	TargetID  Snowflake       `json:"target,omitempty"`
+	Action    int             `json:"action" validate:"required"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Timestamp int             `json:"timestamp" validate:"required"`
+}
+
+type ReadState struct {
+	ChannelID     Snowflake `json:"channel" validate:"required"`
+	LastReadID    Snowflake `json:"lastRead,omitempty"`
+	LastMessageID Snowflake `json:"lastMessage,omitempty"`
+	Unread        bool      `json:"unread,omitempty"`
+	MentionCount  int       `json:"mentionCount,omitempty"`
+}
+
 type Settings struct {
 	SiteName           string `json:"siteName"`
 	LoginMessage       string `json:"loginMessage"`
@@ -250,4 +374,5 @@ const (
 	ErrorCodeInvalidCaptcha     = iota
 	ErrorCodeNoPermission       = iota
 	ErrorCodeConnectionClosing  = iota
+	ErrorCodeBanned             = iota
 )
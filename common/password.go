@@ -0,0 +1,88 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for new hashes, existing hashes keep the parameters they were encoded with
+var (
+	PasswordTime    = uint32(2)
+	PasswordMemory  = uint32(19 * 1024) // KiB
+	PasswordThreads = uint8(1)
+	PasswordKeyLen  = uint32(32)
+	PasswordSaltLen = 16
+)
+
+const passwordPrefix = "$argon2id$"
+
+// Hashes a password with argon2id, encoded in the PHC string format with its salt and parameters
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, PasswordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, PasswordTime, PasswordMemory, PasswordThreads, PasswordKeyLen)
+
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		passwordPrefix,
+		argon2.Version,
+		PasswordMemory,
+		PasswordTime,
+		PasswordThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return encoded, nil
+}
+
+// Checks a password against a stored hash, legacy hashes are salted SHA-256 with a separate salt.
+// Rehash is true when the password is valid but the hash should be replaced with HashPassword.
+func VerifyPassword(password string, hash string, legacySalt string) (valid bool, rehash bool) {
+	if !strings.HasPrefix(hash, passwordPrefix) {
+		legacy := HashSha256(password, legacySalt)
+		valid = subtle.ConstantTimeCompare([]byte(legacy), []byte(hash)) == 1
+		return valid, valid
+	}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false
+	}
+
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(key, expected) != 1 {
+		return false, false
+	}
+
+	outdated := memory != PasswordMemory || time != PasswordTime || threads != PasswordThreads || uint32(len(expected)) != PasswordKeyLen
+	return true, outdated
+}
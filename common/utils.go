@@ -60,10 +60,7 @@ var (
 		"video/x-matroska",
 	}
 
-	DataFolder = "data"
-
-	MaxContentLength    = int64(1024 * 1024 * 64) // 64MB
-	MaxDatabaseFileSize = int64(1024 * 1024)      // 1MB
+	MaxDatabaseFileSize = int64(1024 * 1024) // 1MB
 
 	UserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.10; rv:38.0) Gecko/20100101 Firefox/38.0"
 )
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 
 	. "clack/common"
@@ -14,6 +17,8 @@ import (
 	"clack/network"
 	"clack/storage"
 	"clack/testing"
+
+	"zombiezen.com/go/sqlite"
 )
 
 var mainCtx *ClackContext
@@ -39,20 +44,97 @@ func init() {
 }
 
 func main() {
-	var dataExists bool = false
-	if _, err := os.Stat(DataFolder); err == nil {
-		dataExists = true
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	if !dataExists {
-		os.Mkdir(DataFolder, 0755)
+	var seed testing.SeedOptions
+	var seedForce bool
+	var admin func(db *sqlite.Conn) error
+	var adminForce bool
+	var err error
+
+	switch command {
+	case "":
+		break
+	case "seed":
+		seed, seedForce, args, err = parseSeedFlags(args)
+		break
+	case "admin":
+		admin, adminForce, args, err = parseAdminFlags(args)
+		break
+	default:
+		err = fmt.Errorf("unknown command '%s', expected seed or admin", command)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		mainLog.Fatalf("Invalid arguments: %v", err)
+	}
+
+	config, err := LoadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		mainLog.Fatalf("Invalid configuration: %v", err)
+	}
+	Config = config
+
+	if command == "admin" {
+		// Maintenance works on an existing database, never create one
+		if _, err := os.Stat(Config.DataFolder); err != nil {
+			mainLog.Fatalf("No data folder at %s", Config.DataFolder)
+		}
+	} else {
+		mainLog.Println("Configuration:")
+		for _, line := range Config.Describe() {
+			mainLog.Println("  " + line)
+		}
+
+		if _, err := os.Stat(Config.DataFolder); err != nil {
+			os.Mkdir(Config.DataFolder, 0755)
+		}
+	}
+
+	if err := storage.LockDatabase(); err != nil {
+		if !errors.Is(err, storage.ErrDatabaseLocked) || !adminForce {
+			mainLog.Fatalf("Cannot use the database: %v", err)
+		}
+		mainLog.Println("Database is in use by a running server, continuing because of -force")
+		mainLog.Println("The server won't see these changes until it restarts")
 	}
 
 	storage.StartDatabase(mainCtx)
-	if !dataExists {
-		mainLog.Println("Populating database")
-		testing.PopulateDatabase(mainCtx)
-		mainLog.Println("Done")
+
+	if command == "admin" {
+		err := runAdmin(admin)
+		mainCtx.Cancel()
+		mainCtx.Subsystems.Wait()
+		if err != nil {
+			mainLog.Fatalf("Failed: %v", err)
+		}
+		return
+	}
+
+	if command == "seed" {
+		err := runSeed(seed, seedForce)
+		mainCtx.Cancel()
+		mainCtx.Subsystems.Wait()
+		if err != nil {
+			mainLog.Fatalf("Seeding failed: %v", err)
+		}
+		return
+	}
+
+	if err := runSetup(); err != nil {
+		mainLog.Printf("Setup failed: %v", err)
+		mainCtx.Cancel()
+		mainCtx.Subsystems.Wait()
+		os.Exit(1)
 	}
 
 	network.StartServer(mainCtx)
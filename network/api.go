@@ -31,7 +31,11 @@ func gatewayHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chat.HandleGatewayConnection(srvCtx, conn, token)
+	// Reconnecting clients pass their previous session and the last sequence number they saw
+	resume := chat.ResumeRequest{Session: r.URL.Query().Get("session")}
+	resume.Sequence, _ = strconv.ParseInt(r.URL.Query().Get("sequence"), 10, 64)
+
+	chat.HandleGatewayConnection(srvCtx, conn, token, resume)
 }
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
@@ -156,6 +156,44 @@ func avatarHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, avatar.Name, avatar.Modified, avatar.Content)
 }
 
+func emojiHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	emojiIDInt64, err := strconv.ParseInt(vars["emoji_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid emoji id", http.StatusBadRequest)
+		return
+	}
+	emojiID := snowflake.Snowflake(emojiIDInt64)
+
+	emojiType := r.URL.Query().Get("type")
+	if emojiType == "" {
+		emojiType = "static"
+	}
+	if emojiType != "static" && emojiType != "animated" {
+		http.Error(w, "invalid type", http.StatusBadRequest)
+		return
+	}
+
+	emoji, err := storage.GetEmojiImage(emojiID, emojiType)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			http.Error(w, "emoji not found", http.StatusNotFound)
+			return
+		}
+		srvLog.Printf("Failed to get emoji (Emoji ID: %d, Type: %s): %v", emojiID, emojiType, err)
+		http.Error(w, "failed to get emoji", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", emoji.Mimetype)
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+
+	http.ServeContent(w, r, emoji.Name, emoji.Modified, emoji.Content)
+
+	emoji.Content.Close()
+}
+
 func externalHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
@@ -243,4 +281,5 @@ func buildMediaRouter(router *mux.Router) {
 	router.HandleFunc("/attachments/{message_id}/{attachment_id}/{attachment_name}", attachmentHandler)
 	router.HandleFunc("/external/{message_id}/{embed_id}", externalHandler)
 	router.HandleFunc("/avatars/{user_id}/{modified}", avatarHandler)
+	router.HandleFunc("/emojis/{emoji_id}", emojiHandler)
 }
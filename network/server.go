@@ -22,8 +22,8 @@ func buildRouter() *mux.Router {
 	buildAPIRouter(r)
 	buildMediaRouter(r)
 
-	distDir := "/var/www/clack"
-	if _, err := http.Dir(distDir).Open("index.html"); err == nil {
+	distDir := common.Config.DistFolder
+	if _, err := http.Dir(distDir).Open("index.html"); distDir != "" && err == nil {
 		fileServer := http.FileServer(http.Dir(distDir))
 		r.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path == "/" {
@@ -32,13 +32,12 @@ func buildRouter() *mux.Router {
 			}
 			fileServer.ServeHTTP(w, r)
 		}))
-	} else {
+	} else if common.Config.DevProxy != "" {
+		// Validated with the rest of the config
+		target, _ := url.Parse(common.Config.DevProxy)
 		r.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			proxy := httputil.NewSingleHostReverseProxy(&url.URL{
-				Scheme: "http",
-				Host:   "localhost:5173",
-			})
-			req.Host = "localhost:5173"
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			req.Host = target.Host
 			proxy.ServeHTTP(w, req)
 		}))
 	}
@@ -49,14 +48,14 @@ func buildRouter() *mux.Router {
 func StartServer(ctx *common.ClackContext) {
 	srvCtx = ctx
 	ctx.Subsystems.Add(1)
-	port := ":8000"
+	port := common.Config.Listen
 
 	srvLog.Printf("Starting on %s\n", port)
 
 	r := buildRouter()
 
 	srv := &http.Server{
-		Addr:    ":8000",
+		Addr:    port,
 		Handler: r,
 	}
 
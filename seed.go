@@ -0,0 +1,53 @@
+package main
+
+import (
+	"clack/storage"
+	"clack/testing"
+	"flag"
+	"fmt"
+)
+
+// Flags for "clack seed", anything after "--" is passed on to the config
+func parseSeedFlags(args []string) (testing.SeedOptions, bool, []string, error) {
+	opts := testing.DefaultSeedOptions()
+
+	flags := flag.NewFlagSet("clack seed", flag.ContinueOnError)
+	flags.IntVar(&opts.Users, "users", opts.Users, "number of fake users")
+	flags.IntVar(&opts.Channels, "channels", opts.Channels, "number of channels")
+	flags.IntVar(&opts.Messages, "messages", opts.Messages, "number of messages per channel")
+	flags.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed")
+	force := flags.Bool("force", false, "seed even if the database already has users")
+
+	if err := flags.Parse(args); err != nil {
+		return opts, false, nil, err
+	}
+
+	return opts, *force, flags.Args(), opts.Validate()
+}
+
+func runSeed(opts testing.SeedOptions, force bool) error {
+	db, err := storage.OpenConnection(mainCtx)
+	if err != nil {
+		return err
+	}
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	users, err := tx.GetUserCount()
+	tx.Commit(err)
+	storage.CloseConnection(db)
+
+	if err != nil {
+		return err
+	}
+
+	if users > 0 && !force {
+		return fmt.Errorf("database already has %d users, use -force to seed anyway", users)
+	}
+
+	mainLog.Printf("Seeding %d users, %d channels, %d messages per channel (seed %d)", opts.Users, opts.Channels, opts.Messages, opts.Seed)
+	testing.PopulateDatabase(mainCtx, opts)
+	mainLog.Println("Done")
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	. "clack/common"
+	"clack/storage"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Creates the first administrator on an empty database, from the config or by asking on the terminal
+func runSetup() error {
+	db, err := storage.OpenConnection(mainCtx)
+	if err != nil {
+		return err
+	}
+	defer storage.CloseConnection(db)
+
+	tx := storage.NewTransaction(db)
+	tx.Start()
+	users, err := tx.GetUserCount()
+	admins := 0
+	if err == nil {
+		admins, err = tx.GetAdministratorCount()
+	}
+	tx.Commit(err)
+	if err != nil {
+		return err
+	}
+
+	if admins > 0 {
+		return nil
+	}
+
+	// Not a first run, an existing server shouldn't stop starting over this
+	if users > 0 {
+		mainLog.Println("Warning: no user has an administrator role, grant one with 'clack admin role-add'")
+		return nil
+	}
+
+	mainLog.Println("No users found, running first-run setup")
+
+	username, password := Config.Admin.Username, Config.Admin.Password
+	if username == "" || password == "" {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("first-run setup needs an administrator, set admin.username and admin.password (or CLACK_ADMIN_USERNAME and CLACK_ADMIN_PASSWORD)")
+		}
+		if username, password, err = promptCredentials("Administrator", username, password); err != nil {
+			return fmt.Errorf("reading administrator credentials: %v", err)
+		}
+	}
+
+	// Clients hash the password before sending it
+	hash, err := HashPassword(HashSha256(password, ""))
+	if err != nil {
+		return err
+	}
+
+	tx.Start()
+	err = createAdmin(tx, username, hash)
+	tx.Commit(err)
+	if err != nil {
+		return err
+	}
+
+	mainLog.Printf("Created administrator '%s'", username)
+	return nil
+}
+
+func createAdmin(tx *storage.Transaction, username string, hash string) error {
+	settings, err := tx.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	// Never configured, start from usable defaults
+	if settings.SiteName == "" {
+		settings.SiteName = "Clack"
+		settings.DefaultPermissions = PermissionDefault
+		if err := tx.SetSettings(settings); err != nil {
+			return err
+		}
+	}
+
+	roleID, err := tx.AddRole("Admin", 0xa84300, 0, PermissionAdministrator, true, true)
+	if err != nil {
+		return err
+	}
+
+	userID, err := tx.AddUser(username, hash, "", "", "")
+	if err != nil {
+		return err
+	}
+
+	if err := tx.SetUserProfile(userID, username, "", "", ProfileColorDefault, AvatarModifiedDefault); err != nil {
+		return err
+	}
+
+	return tx.AddRoleToUser(userID, roleID)
+}
+
+func promptCredentials(label string, username string, password string) (string, string, error) {
+	reader := bufio.NewReader(os.Stdin)
+
+	for username == "" {
+		fmt.Printf("%s username: ", label)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		username = strings.TrimSpace(line)
+	}
+
+	for password == "" {
+		fmt.Printf("%s password: ", label)
+		echo(false)
+		line, err := reader.ReadString('\n')
+		echo(true)
+		fmt.Println()
+		if err != nil {
+			return "", "", err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	return username, password, nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Best effort, the password is still read if stty isn't available
+func echo(on bool) {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+	cmd.Run()
+}
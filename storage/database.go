@@ -4,9 +4,12 @@ import (
 	. "clack/common"
 	"context"
 	_ "embed"
+	"errors"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitemigration"
@@ -16,22 +19,27 @@ import (
 //go:embed sql/database_schema.sql
 var schema string
 
-var dbFile = filepath.Join(DataFolder, "database.db")
+var dbFile string
 var dbPool *sqlitemigration.Pool
 var dbPoolWait sync.WaitGroup
 var dbLog = NewLogger("DATABASE")
+var dbLock *os.File
+
+var ErrDatabaseLocked = errors.New("database is in use by another process")
 
 func StartDatabase(ctx *ClackContext) {
 	ctx.Subsystems.Add(1)
 	dbLog.Println("Starting")
 
+	dbFile = filepath.Join(Config.DataFolder, "database.db")
+
 	schema := sqlitemigration.Schema{
 		Migrations: strings.Split(schema, "\n\n"),
 	}
 
 	dbPool = sqlitemigration.NewPool(dbFile, schema, sqlitemigration.Options{
 		Flags:    sqlite.OpenReadWrite | sqlite.OpenCreate,
-		PoolSize: 16,
+		PoolSize: Config.PoolSize,
 
 		PrepareConn: func(conn *sqlite.Conn) error {
 			sqlitex.ExecuteTransient(conn, "PRAGMA journal_mode = WAL;", nil)
@@ -63,6 +71,25 @@ func StartDatabase(ctx *ClackContext) {
 	}()
 }
 
+// Held for as long as the process runs, so offline tools can tell a live server owns the database
+func LockDatabase() error {
+	file, err := os.OpenFile(filepath.Join(Config.DataFolder, "database.lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return ErrDatabaseLocked
+		}
+		return err
+	}
+
+	dbLock = file
+	return nil
+}
+
 func CheckpointDatabase() {
 	pool, _ := sqlitex.NewPool(dbFile, sqlitex.PoolOptions{
 		Flags:    sqlite.OpenReadWrite | sqlite.OpenCreate,
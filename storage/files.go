@@ -9,6 +9,8 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/gabriel-vasile/mimetype"
 )
@@ -25,8 +27,12 @@ func GetAvatarPath(userID Snowflake, modified int64, size string) string {
 	return fmt.Sprintf("avatars/%d/%d/%s", userID, modified, size)
 }
 
+func GetEmojiPath(emojiID Snowflake, typ string) string {
+	return fmt.Sprintf("emojis/%d/%s", emojiID, typ)
+}
+
 func WriteFile(path string, input FileInputReader) error {
-	file := filepath.Join(DataFolder, path)
+	file := filepath.Join(Config.DataFolder, path)
 	os.MkdirAll(filepath.Dir(file), 0755)
 
 	disk, err := os.Create(file)
@@ -46,7 +52,7 @@ func WriteFile(path string, input FileInputReader) error {
 }
 
 func ReadFile(path string) (FileOutputReader, error) {
-	file := filepath.Join(DataFolder, path)
+	file := filepath.Join(Config.DataFolder, path)
 	disk, err := os.Open(file)
 	if err == nil {
 		_, err := disk.Stat()
@@ -101,7 +107,7 @@ func UploadAttachment(messageID Snowflake, attachmentID Snowflake, filename stri
 
 	var previews *Previews = nil
 	if attachment.Type != AttachmentTypeFile {
-		if absPath, err := filepath.Abs(filepath.Join(DataFolder, path)); err != nil {
+		if absPath, err := filepath.Abs(filepath.Join(Config.DataFolder, path)); err != nil {
 			fmt.Println("Failed to get absolute path:", err)
 			attachment.Type = AttachmentTypeFile
 		} else {
@@ -182,8 +188,130 @@ func UploadAvatar(userID Snowflake, modified int64, input FileInputReader) error
 	return nil
 }
 
+const EmojiMaxSize = 512 * 1024
+const EmojiMaxDimension = 128
+
+// Converts an uploaded image into emoji, GIFs also get an animated version. Returns whether it's animated
+func UploadEmoji(emojiID Snowflake, input FileInputReader) (bool, error) {
+	content, err := io.ReadAll(io.LimitReader(input, EmojiMaxSize+1))
+	if err != nil {
+		return false, fmt.Errorf("failed to read emoji: %w", err)
+	}
+	if len(content) > EmojiMaxSize {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("emoji exceeds %d bytes", EmojiMaxSize))
+	}
+
+	mimeType := mimetype.Detect(content).String()
+	if !slices.Contains(SupportedImageTypes, mimeType) {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("unsupported emoji type %s", mimeType))
+	}
+	animated := mimeType == "image/gif"
+
+	image, err := CreateEmoji(content, animated)
+	if err != nil {
+		return false, NewError(ErrorCodeInvalidRequest, err)
+	}
+
+	err = WriteFile(GetEmojiPath(emojiID, "static"), bytes.NewReader(image.Static))
+	if err != nil {
+		return false, fmt.Errorf("failed to write static emoji: %w", err)
+	}
+
+	if animated {
+		err = WriteFile(GetEmojiPath(emojiID, "animated"), bytes.NewReader(image.Animated))
+		if err != nil {
+			return false, fmt.Errorf("failed to write animated emoji: %w", err)
+		}
+	}
+
+	return animated, nil
+}
+
+func DeleteEmojiImages(emojiID Snowflake) error {
+	return os.RemoveAll(filepath.Join(Config.DataFolder, filepath.Dir(GetEmojiPath(emojiID, "static"))))
+}
+
+// Removes the attachments and previews stored for a message
+func DeleteMessageFiles(messageID Snowflake) error {
+	attachments := filepath.Dir(GetAttachmentPath(messageID, 0))
+	if err := os.RemoveAll(filepath.Join(Config.DataFolder, attachments)); err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	previews := filepath.Dir(filepath.Dir(GetPreviewPath(messageID, 0, "original")))
+	if err := os.RemoveAll(filepath.Join(Config.DataFolder, previews)); err != nil {
+		return fmt.Errorf("failed to delete previews: %w", err)
+	}
+
+	return nil
+}
+
+// Maps every attachment ID on disk to the message ID it's stored under
+func ListAttachmentFiles() (map[Snowflake]Snowflake, error) {
+	files := map[Snowflake]Snowflake{}
+
+	root := filepath.Join(Config.DataFolder, "attachments")
+	messages, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range messages {
+		messageID, err := strconv.ParseInt(message.Name(), 10, 64)
+		if err != nil || !message.IsDir() {
+			continue
+		}
+
+		attachments, err := os.ReadDir(filepath.Join(root, message.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attachment := range attachments {
+			if attachmentID, err := strconv.ParseInt(attachment.Name(), 10, 64); err == nil {
+				files[Snowflake(attachmentID)] = Snowflake(messageID)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// Sums file sizes under each top level folder of the data folder, loose files count under ""
+func GetDiskUsage() (map[string]int64, error) {
+	usage := map[string]int64{}
+
+	err := filepath.WalkDir(Config.DataFolder, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(Config.DataFolder, path)
+		folder := ""
+		if parts := strings.SplitN(filepath.ToSlash(rel), "/", 2); len(parts) == 2 {
+			folder = parts[0]
+		}
+		usage[folder] += info.Size()
+
+		return nil
+	})
+
+	return usage, err
+}
+
 func GetFile(name string) (*File, error) {
-	disk, err := os.Open(filepath.Join(DataFolder, name))
+	disk, err := os.Open(filepath.Join(Config.DataFolder, name))
 	if err == nil {
 		stat, err := disk.Stat()
 		if err != nil {
@@ -235,3 +363,15 @@ func GetAvatar(userID Snowflake, modified int64, typ string) (*File, error) {
 	file.Mimetype = "image/webp"
 	return file, nil
 }
+
+func GetEmojiImage(emojiID Snowflake, typ string) (*File, error) {
+	name := GetEmojiPath(emojiID, typ)
+	file, err := GetFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file.Mimetype = "image/webp"
+	return file, nil
+}
@@ -165,3 +165,52 @@ func CreateAvatar(content io.Reader) (*Avatar, error) {
 
 	return &a, nil
 }
+
+type EmojiImage struct {
+	Static   []byte
+	Animated []byte
+}
+
+func CreateEmoji(content []byte, animated bool) (*EmojiImage, error) {
+	var e EmojiImage
+	var err error
+
+	scale := fmt.Sprintf("scale=w='min(iw,%d)':h='min(ih,%d)':force_original_aspect_ratio=decrease", EmojiMaxDimension, EmojiMaxDimension)
+
+	staticArgs := []string{
+		"-threads", "1",
+		"-i", "-",
+		"-vframes", "1",
+		"-quality", "100",
+		"-c:v", "libwebp",
+		"-f", "image2pipe",
+		"-vf", scale,
+		"-",
+	}
+
+	e.Static, err = runFFmpegOnStream(staticArgs, bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create static emoji: %w", err)
+	}
+
+	if !animated {
+		return &e, nil
+	}
+
+	animatedArgs := []string{
+		"-threads", "1",
+		"-i", "-",
+		"-c:v", "libwebp_anim",
+		"-loop", "0",
+		"-f", "image2pipe",
+		"-vf", scale,
+		"-",
+	}
+
+	e.Animated, err = runFFmpegOnStream(animatedArgs, bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create animated emoji: %w", err)
+	}
+
+	return &e, nil
+}
@@ -1,10 +1,12 @@
 package storage
 
 import (
+	. "clack/common"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
+	"strconv"
 )
 
 func findNobody() *user.User {
@@ -40,6 +42,7 @@ func sandboxFFmpegCommand(tmpPath string, args ...string) (*exec.Cmd, error) {
 	}
 
 	pwd, _ := os.Getwd()
+	limits := Config.Sandbox
 	nsArgs := []string{
 		"-Mo",
 		"--user", nobody.Uid, "--group", nobody.Gid,
@@ -50,14 +53,14 @@ func sandboxFFmpegCommand(tmpPath string, args ...string) (*exec.Cmd, error) {
 		"--disable_proc",
 		"--iface_no_lo",
 
-		"--rlimit_as", "1024",
+		"--rlimit_as", strconv.Itoa(limits.AddressSpace),
 		"--rlimit_core", "0",
-		"--rlimit_cpu", "10",
+		"--rlimit_cpu", strconv.Itoa(limits.CPU),
 		"--rlimit_fsize", "0",
-		"--rlimit_nofile", "128",
-		"--rlimit_nproc", "128",
-		"--rlimit_stack", "8",
-		"--rlimit_memlock", "64",
+		"--rlimit_nofile", strconv.Itoa(limits.Files),
+		"--rlimit_nproc", strconv.Itoa(limits.Processes),
+		"--rlimit_stack", strconv.Itoa(limits.Stack),
+		"--rlimit_memlock", strconv.Itoa(limits.Memlock),
 		"--rlimit_rtprio", "0",
 		"--rlimit_msgqueue", "0",
 
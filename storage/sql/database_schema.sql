@@ -229,4 +229,114 @@ AFTER DELETE ON emojis
 FOR EACH ROW
 BEGIN
     DELETE FROM reactions WHERE emoji_id = OLD.id;
-END;
\ No newline at end of file
+END;
+
+ALTER TABLE user_tokens ADD COLUMN ip TEXT;
+
+ALTER TABLE users ADD COLUMN invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+CREATE UNIQUE INDEX idx_user_invite_codes_invite_code ON user_invite_codes(invite_code);
+
+CREATE TABLE user_bans (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER,
+    user_name TEXT,
+    ip TEXT,
+    moderator_id INTEGER,
+    reason TEXT,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (moderator_id) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX idx_user_bans_user_id ON user_bans(user_id);
+CREATE INDEX idx_user_bans_user_name ON user_bans(user_name);
+CREATE INDEX idx_user_bans_ip ON user_bans(ip);
+CREATE TABLE user_silences (
+    user_id INTEGER PRIMARY KEY,
+    moderator_id INTEGER,
+    reason TEXT,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (moderator_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY,
+    actor_id INTEGER,
+    target_id INTEGER,
+    action INTEGER NOT NULL,
+    before TEXT,
+    after TEXT,
+    reason TEXT,
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX idx_audit_log_actor_id ON audit_log(actor_id);
+CREATE INDEX idx_audit_log_target_id ON audit_log(target_id);
+CREATE INDEX idx_audit_log_action ON audit_log(action);
+
+CREATE VIRTUAL TABLE messages_fts USING fts5(content, content='messages', content_rowid='id');
+CREATE TRIGGER messages_fts_insert
+AFTER INSERT ON messages
+FOR EACH ROW
+BEGIN
+    INSERT INTO messages_fts(rowid, content) VALUES (NEW.id, NEW.content);
+END;
+CREATE TRIGGER messages_fts_delete
+AFTER DELETE ON messages
+FOR EACH ROW
+BEGIN
+    INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', OLD.id, OLD.content);
+END;
+CREATE TRIGGER messages_fts_update
+AFTER UPDATE OF content ON messages
+FOR EACH ROW
+BEGIN
+    INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', OLD.id, OLD.content);
+    INSERT INTO messages_fts(rowid, content) VALUES (NEW.id, NEW.content);
+END;
+INSERT INTO messages_fts(messages_fts) VALUES ('rebuild');
+
+CREATE TABLE channel_members (
+    channel_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    PRIMARY KEY (channel_id, user_id),
+    FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_channel_members_user_id ON channel_members(user_id);
+
+CREATE TABLE threads (
+    channel_id INTEGER PRIMARY KEY,
+    message_id INTEGER UNIQUE,
+    archived INTEGER DEFAULT 0 NOT NULL,
+    last_activity INTEGER NOT NULL,
+    FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE SET NULL
+);
+CREATE INDEX idx_threads_archived ON threads(archived, last_activity);
+
+ALTER TABLE messages ADD COLUMN mentions_everyone INTEGER DEFAULT 0 NOT NULL;
+CREATE TABLE read_states (
+    user_id INTEGER NOT NULL,
+    channel_id INTEGER NOT NULL,
+    last_message_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, channel_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+ALTER TABLE emojis ADD COLUMN animated INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE emojis ADD COLUMN uploader_id INTEGER;
+ALTER TABLE emojis ADD COLUMN created_at INTEGER DEFAULT 0 NOT NULL;
+CREATE UNIQUE INDEX idx_emojis_name ON emojis(name);
+
+CREATE TABLE message_emojis (
+    message_id INTEGER NOT NULL,
+    emoji_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    animated INTEGER DEFAULT 0 NOT NULL,
+    PRIMARY KEY (message_id, emoji_id),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_message_emojis_emoji_id ON message_emojis(emoji_id);
@@ -8,6 +8,7 @@ SELECT
     m.reference_id,
     m.content,
     m.edited_timestamp,
+    m.mentions_everyone,
     
     -- Aggregate Attachments
     (
@@ -191,7 +192,33 @@ SELECT
         SELECT json_group_array(channel_id)
         FROM message_channel_mentions
         WHERE message_id = m.id
-    ) AS mentioned_channels
+    ) AS mentioned_channels,
+    
+    -- Custom Emojis, deleted ones keep the name they had when used
+    (
+        SELECT json_group_array(
+            json_object(
+                'id', me.emoji_id,
+                'name', COALESCE(e.name, me.name),
+                'animated', json(CASE WHEN me.animated != 0 THEN 'true' ELSE 'false' END)
+            )
+        )
+        FROM message_emojis me
+        LEFT JOIN emojis e ON e.id = me.emoji_id
+        WHERE me.message_id = m.id
+    ) AS emojis,
+    
+    -- Thread Summary
+    (
+        SELECT json_object(
+            'channel', t.channel_id,
+            'replyCount', (SELECT COUNT(*) FROM messages tm WHERE tm.channel_id = t.channel_id),
+            'lastActivity', t.last_activity,
+            'archived', json(CASE WHEN t.archived != 0 THEN 'true' ELSE 'false' END)
+        )
+        FROM threads t
+        WHERE t.message_id = m.id
+    ) AS thread
 
 FROM
     messages m;
\ No newline at end of file
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -166,14 +167,47 @@ func (tx *Transaction) Initialize() {
 	}
 }
 
-func (tx *Transaction) Checkpoint() {
-	tx.MarkAsWrite()
+// Checkpointing and vacuuming can't happen inside a transaction, don't call Start before them
+func (tx *Transaction) Checkpoint() error {
 	stmt := tx.Prepare("PRAGMA wal_checkpoint(TRUNCATE);")
 	defer tx.Finish(stmt)
 
 	if _, err := tx.Execute(stmt); err != nil {
-		panic(NewError(ErrorCodeInternalError, fmt.Errorf("failed to checkpoint database: %w", err)))
+		return NewError(ErrorCodeInternalError, fmt.Errorf("failed to checkpoint database: %w", err))
+	}
+
+	return nil
+}
+
+func (tx *Transaction) Vacuum() error {
+	stmt := tx.Prepare("VACUUM;")
+	defer tx.Finish(stmt)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, fmt.Errorf("failed to vacuum database: %w", err))
+	}
+
+	return nil
+}
+
+// Lists rows whose foreign keys point at nothing, as "table rowid -> parent"
+func (tx *Transaction) CheckForeignKeys() ([]string, error) {
+	stmt := tx.Prepare("PRAGMA foreign_key_check;")
+	defer tx.Finish(stmt)
+
+	violations := []string{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		violations = append(violations, fmt.Sprintf("%s %d -> %s", stmt.ColumnText(0), stmt.ColumnInt64(1), stmt.ColumnText(2)))
 	}
+
+	return violations, nil
 }
 
 func (tx *Transaction) QueryUsers(id Snowflake) ([]User, error) {
@@ -263,6 +297,54 @@ func (tx *Transaction) GetAllUsers() ([]User, error) {
 	return tx.QueryUsers(0)
 }
 
+func (tx *Transaction) GetUserIDByName(username string) (Snowflake, error) {
+	stmt := tx.Prepare(`SELECT id FROM users WHERE user_name = $user_name;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetText("$user_name", username)
+
+	hasRow, err := stmt.Step()
+	if err != nil {
+		return 0, NewError(ErrorCodeInternalError, err)
+	}
+
+	if !hasRow {
+		return 0, NewError(ErrorCodeInvalidRequest, fmt.Errorf("user '%s' not found", username))
+	}
+
+	return Snowflake(stmt.GetInt64("id")), nil
+}
+
+func (tx *Transaction) GetUserCount() (int, error) {
+	stmt := tx.Prepare(`SELECT COUNT(*) AS count FROM users;`)
+	defer tx.Finish(stmt)
+
+	if _, err := stmt.Step(); err != nil {
+		return 0, NewError(ErrorCodeInternalError, err)
+	}
+
+	return int(stmt.GetInt64("count")), nil
+}
+
+// Counts users holding a role with the administrator permission
+func (tx *Transaction) GetAdministratorCount() (int, error) {
+	stmt := tx.Prepare(`
+		SELECT COUNT(DISTINCT ur.user_id) AS count
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE r.permissions & $administrator != 0;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$administrator", int64(PermissionAdministrator))
+
+	if _, err := stmt.Step(); err != nil {
+		return 0, NewError(ErrorCodeInternalError, err)
+	}
+
+	return int(stmt.GetInt64("count")), nil
+}
+
 func (tx *Transaction) QueryChannels(id Snowflake) ([]Channel, error) {
 	query := `SELECT
 			c.id,
@@ -276,13 +358,18 @@ func (tx *Transaction) QueryChannels(id Snowflake) ([]Channel, error) {
 			crp.deny AS role_deny,
 			cup.user_id,
 			cup.allow AS user_allow,
-			cup.deny AS user_deny
+			cup.deny AS user_deny,
+			t.message_id AS thread_message_id,
+			t.archived AS thread_archived,
+			t.last_activity AS thread_last_activity
 		FROM
 			channels c
 		LEFT JOIN
 			channel_role_permissions crp ON c.id = crp.channel_id
 		LEFT JOIN
-			channel_user_permissions cup ON c.id = cup.channel_id`
+			channel_user_permissions cup ON c.id = cup.channel_id
+		LEFT JOIN
+			threads t ON c.id = t.channel_id`
 	if id != 0 {
 		query += ` WHERE c.id = $id`
 	}
@@ -313,6 +400,10 @@ func (tx *Transaction) QueryChannels(id Snowflake) ([]Channel, error) {
 			Position:    int(stmt.GetInt64("position")),
 			ParentID:    Snowflake(stmt.GetInt64("parent_id")),
 			Overwrites:  []Overwrite{},
+
+			MessageID:    Snowflake(stmt.GetInt64("thread_message_id")),
+			Archived:     stmt.GetInt64("thread_archived") != 0,
+			LastActivity: int(stmt.GetInt64("thread_last_activity")),
 		}
 
 		if currentPermissions[channel.ID] == nil {
@@ -361,9 +452,54 @@ func (tx *Transaction) QueryChannels(id Snowflake) ([]Channel, error) {
 		channels = append(channels, *currentChannel)
 	}
 
+	members, err := tx.QueryChannelMembers(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range channels {
+		if channels[i].IsPrivate() {
+			channels[i].Recipients = members[channels[i].ID]
+		}
+	}
+
 	return channels, nil
 }
 
+// Returns the members of private channels, keyed by channel (0 for all channels)
+func (tx *Transaction) QueryChannelMembers(channelID Snowflake) (map[Snowflake][]Snowflake, error) {
+	query := `SELECT
+			channel_id,
+			user_id
+		FROM
+			channel_members`
+	if channelID != 0 {
+		query += ` WHERE channel_id = $channel_id`
+	}
+	stmt := tx.Prepare(query + ` ORDER BY channel_id, user_id;`)
+	defer tx.Finish(stmt)
+
+	if channelID != 0 {
+		stmt.SetInt64("$channel_id", int64(channelID))
+	}
+
+	members := make(map[Snowflake][]Snowflake)
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		id := Snowflake(stmt.GetInt64("channel_id"))
+		members[id] = append(members[id], Snowflake(stmt.GetInt64("user_id")))
+	}
+
+	return members, nil
+}
+
 func (tx *Transaction) GetChannel(id Snowflake) (Channel, error) {
 	channels, err := tx.QueryChannels(id)
 	if err != nil {
@@ -435,113 +571,51 @@ func (tx *Transaction) AddChannel(name string, channelType int, description stri
 	return channelID, nil
 }
 
-func (tx *Transaction) QueryRoles(id Snowflake) ([]Role, error) {
-	query := `SELECT
-			id,
-			name,
-			color,
-			position,
-			permissions,
-			hoisted,
-			mentionable
-		FROM
-			roles`
-	if id != 0 {
-		query += ` WHERE id = $id`
-	}
-	stmt := tx.Prepare(query + ` ORDER BY id;`)
-	defer tx.Finish(stmt)
-
-	if id != 0 {
-		stmt.SetInt64("$id", int64(id))
+func (tx *Transaction) AddPrivateChannel(channelType int, name string, members []Snowflake) (Snowflake, error) {
+	if channelType != ChannelTypeDM && channelType != ChannelTypeGroupDM {
+		return 0, NewError(ErrorCodeInvalidRequest, fmt.Errorf("invalid private channel type %d", channelType))
 	}
 
-	roles := []Role{}
-
-	for {
-		hasRow, stepErr := stmt.Step()
-		if stepErr != nil {
-			return nil, NewError(ErrorCodeInternalError, stepErr)
-		}
-		if !hasRow {
-			break
-		}
-		role := Role{
-			ID:          Snowflake(stmt.GetInt64("id")),
-			Name:        stmt.GetText("name"),
-			Color:       int(stmt.GetInt64("color")),
-			Permissions: int(stmt.GetInt64("permissions")),
-			Position:    int(stmt.GetInt64("position")),
-			Hoisted:     stmt.GetInt64("hoisted") != 0,
-			Mentionable: stmt.GetInt64("mentionable") != 0,
-		}
-		roles = append(roles, role)
+	if len(name) > 100 {
+		return 0, NewError(ErrorCodeInvalidRequest, fmt.Errorf("channel name must be at most 100 characters long"))
 	}
 
-	return roles, nil
-}
-
-func (tx *Transaction) GetRole(id Snowflake) (Role, error) {
-	roles, err := tx.QueryRoles(id)
+	channelID, err := tx.AddChannel(name, channelType, "", 0, 0)
 	if err != nil {
-		return Role{}, err
+		return 0, err
 	}
-	if len(roles) == 0 {
-		return Role{}, NewError(ErrorCodeInvalidRequest, fmt.Errorf("role not found"))
+
+	for _, userID := range members {
+		if err := tx.AddChannelMember(channelID, userID); err != nil {
+			return 0, err
+		}
 	}
-	return roles[0], nil
-}
 
-func (tx *Transaction) GetAllRoles() ([]Role, error) {
-	return tx.QueryRoles(0)
+	return channelID, nil
 }
 
-func (tx *Transaction) AddRole(name string, color int, position int, permissions int, hoisted bool, mentionable bool) (Snowflake, error) {
+func (tx *Transaction) AddChannelMember(channelID Snowflake, userID Snowflake) error {
 	tx.MarkAsWrite()
-	stmt := tx.Prepare(`
-		INSERT INTO roles(id, name, color, position, permissions, hoisted, mentionable)
-		VALUES ($id, $name, $color, $position, $permissions, $hoisted, $mentionable);`,
-	)
+	stmt := tx.Prepare(`INSERT OR IGNORE INTO channel_members(channel_id, user_id) VALUES ($channel_id, $user_id);`)
 	defer tx.Finish(stmt)
 
-	roleID := snowflake.New()
-
-	stmt.SetInt64("$id", int64(roleID))
-	stmt.SetText("$name", name)
-	stmt.SetInt64("$color", int64(color))
-	stmt.SetInt64("$position", int64(position))
-	stmt.SetInt64("$permissions", int64(permissions))
-	stmt.SetBool("$hoisted", hoisted)
-	stmt.SetBool("$mentionable", mentionable)
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetInt64("$user_id", int64(userID))
 
 	if _, err := tx.Execute(stmt); err != nil {
-		return 0, NewError(ErrorCodeInternalError, err)
+		return NewError(ErrorCodeInternalError, err)
 	}
 
-	return roleID, nil
+	return nil
 }
 
-func (tx *Transaction) UpdateRole(id Snowflake, name string, color int, position int, permissions int, hoisted bool, mentionable bool) error {
+func (tx *Transaction) DeleteChannelMember(channelID Snowflake, userID Snowflake) error {
 	tx.MarkAsWrite()
-	stmt := tx.Prepare(`UPDATE roles
-		SET
-			name = $name,
-			color = $color,
-			position = $position,
-			permissions = $permissions,
-			hoisted = $hoisted,
-			mentionable = $mentionable
-		WHERE id = $id;`,
-	)
+	stmt := tx.Prepare(`DELETE FROM channel_members WHERE channel_id = $channel_id AND user_id = $user_id;`)
 	defer tx.Finish(stmt)
 
-	stmt.SetInt64("$id", int64(id))
-	stmt.SetText("$name", name)
-	stmt.SetInt64("$color", int64(color))
-	stmt.SetInt64("$position", int64(position))
-	stmt.SetInt64("$permissions", int64(permissions))
-	stmt.SetBool("$hoisted", hoisted)
-	stmt.SetBool("$mentionable", mentionable)
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetInt64("$user_id", int64(userID))
 
 	if _, err := tx.Execute(stmt); err != nil {
 		return NewError(ErrorCodeInternalError, err)
@@ -550,125 +624,1147 @@ func (tx *Transaction) UpdateRole(id Snowflake, name string, color int, position
 	return nil
 }
 
-func (tx *Transaction) DeleteRole(id Snowflake) error {
-	tx.MarkAsWrite()
-	stmt := tx.Prepare(`DELETE FROM roles WHERE id = $id;`)
+// Returns the DM between two users, 0 if they don't have one
+func (tx *Transaction) GetDMChannel(userID Snowflake, otherID Snowflake) (Snowflake, error) {
+	stmt := tx.Prepare(`
+		SELECT
+			c.id
+		FROM
+			channels c
+		JOIN
+			channel_members a ON a.channel_id = c.id AND a.user_id = $user_id
+		JOIN
+			channel_members b ON b.channel_id = c.id AND b.user_id = $other_id
+		WHERE
+			c.type = $type
+		LIMIT 1;`,
+	)
 	defer tx.Finish(stmt)
 
-	stmt.SetInt64("$id", int64(id))
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetInt64("$other_id", int64(otherID))
+	stmt.SetInt64("$type", ChannelTypeDM)
 
-	if _, err := tx.Execute(stmt); err != nil {
-		return NewError(ErrorCodeInternalError, err)
+	hasRow, err := stmt.Step()
+	if err != nil {
+		return 0, NewError(ErrorCodeInternalError, err)
 	}
 
-	return nil
+	if !hasRow {
+		return 0, nil
+	}
+
+	return Snowflake(stmt.GetInt64("id")), nil
 }
 
-func (tx *Transaction) AddRoleToUser(userID Snowflake, roleID Snowflake) error {
+func (tx *Transaction) AddThread(parentID Snowflake, messageID Snowflake, name string) (Snowflake, error) {
+	if name == "" || len(name) > 100 {
+		return 0, NewError(ErrorCodeInvalidRequest, fmt.Errorf("thread name '%s' must be between 1 and 100 characters long", name))
+	}
+
+	channelID, err := tx.AddChannel(name, ChannelTypeThread, "", 0, parentID)
+	if err != nil {
+		return 0, err
+	}
+
 	tx.MarkAsWrite()
-	stmt := tx.Prepare(`INSERT INTO user_roles(user_id, role_id) VALUES ($user_id, $role_id);`)
+	stmt := tx.Prepare(`
+		INSERT INTO threads(channel_id, message_id, last_activity)
+		VALUES ($channel_id, $message_id, $last_activity);`,
+	)
 	defer tx.Finish(stmt)
 
-	stmt.SetInt64("$user_id", int64(userID))
-	stmt.SetInt64("$role_id", int64(roleID))
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetInt64("$message_id", int64(messageID))
+	stmt.SetInt64("$last_activity", time.Now().UnixMilli())
 
 	if _, err := tx.Execute(stmt); err != nil {
-		return NewError(ErrorCodeInternalError, err)
+		return 0, NewError(ErrorCodeInternalError, err)
 	}
 
-	return nil
+	return channelID, nil
 }
 
-func (tx *Transaction) DeleteRoleFromUser(userID Snowflake, roleID Snowflake) error {
-	tx.MarkAsWrite()
-	stmt := tx.Prepare(`DELETE FROM user_roles WHERE user_id = $user_id AND role_id = $role_id;`)
+// Returns the thread started from a message, 0 if there is none
+func (tx *Transaction) GetThreadByMessage(messageID Snowflake) (Snowflake, error) {
+	stmt := tx.Prepare(`SELECT channel_id FROM threads WHERE message_id = $message_id;`)
 	defer tx.Finish(stmt)
 
-	stmt.SetInt64("$user_id", int64(userID))
-	stmt.SetInt64("$role_id", int64(roleID))
+	stmt.SetInt64("$message_id", int64(messageID))
 
-	if _, err := tx.Execute(stmt); err != nil {
-		return NewError(ErrorCodeInternalError, err)
+	hasRow, err := stmt.Step()
+	if err != nil {
+		return 0, NewError(ErrorCodeInternalError, err)
 	}
 
-	return nil
+	if !hasRow {
+		return 0, nil
+	}
+
+	return Snowflake(stmt.GetInt64("channel_id")), nil
 }
-func (tx *Transaction) QueryEmojis(id Snowflake) ([]Emoji, error) {
+
+// Returns a channel's threads, most recently active first, before is a last activity time (0 for the latest)
+func (tx *Transaction) GetThreads(parentID Snowflake, archived bool, before int, limit int) ([]Channel, error) {
 	query := `SELECT
-			name,
-			id
+			t.channel_id
 		FROM
-			emojis`
-	if id != 0 {
-		query += ` WHERE id = $id`
+			threads t
+		JOIN
+			channels c ON c.id = t.channel_id
+		WHERE
+			c.parent_id = $parent_id AND t.archived = $archived`
+	if before != 0 {
+		query += ` AND t.last_activity < $before`
 	}
+	stmt := tx.Prepare(query + ` ORDER BY t.last_activity DESC LIMIT $limit;`)
 
-	stmt := tx.Prepare(query + ` ORDER BY name;`)
-	defer tx.Finish(stmt)
-
-	if id != 0 {
-		stmt.SetInt64("$id", int64(id))
+	stmt.SetInt64("$parent_id", int64(parentID))
+	stmt.SetInt64("$archived", int64(BoolToInt(archived)))
+	stmt.SetInt64("$limit", int64(limit))
+	if before != 0 {
+		stmt.SetInt64("$before", int64(before))
 	}
 
-	emojis := []Emoji{}
-
+	ids := []Snowflake{}
 	for {
-		hasRow, stepErr := stmt.Step()
-		if stepErr != nil {
-			return nil, NewError(ErrorCodeInternalError, stepErr)
+		hasRow, err := stmt.Step()
+		if err != nil {
+			tx.Finish(stmt)
+			return nil, NewError(ErrorCodeInternalError, err)
 		}
 		if !hasRow {
 			break
 		}
-		emoji := Emoji{
-			Name: stmt.GetText("name"),
-			ID:   Snowflake(stmt.GetInt64("id")),
+		ids = append(ids, Snowflake(stmt.GetInt64("channel_id")))
+	}
+	tx.Finish(stmt)
+
+	threads := make([]Channel, 0, len(ids))
+	for _, id := range ids {
+		thread, err := tx.GetChannel(id)
+		if err != nil {
+			return nil, err
 		}
-		emojis = append(emojis, emoji)
+		threads = append(threads, thread)
+	}
+
+	return threads, nil
+}
+
+// Returns unarchived threads with no activity since the cutoff
+func (tx *Transaction) GetInactiveThreads(cutoff int) ([]Snowflake, error) {
+	stmt := tx.Prepare(`SELECT channel_id FROM threads WHERE archived = 0 AND last_activity < $cutoff;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$cutoff", int64(cutoff))
+
+	ids := []Snowflake{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		ids = append(ids, Snowflake(stmt.GetInt64("channel_id")))
+	}
+
+	return ids, nil
+}
+
+// Records activity in a thread, which also unarchives it
+func (tx *Transaction) SetThreadActivity(channelID Snowflake, timestamp int) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE threads SET last_activity = $last_activity, archived = 0 WHERE channel_id = $channel_id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetInt64("$last_activity", int64(timestamp))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) SetThreadArchived(channelID Snowflake, archived bool) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE threads SET archived = $archived WHERE channel_id = $channel_id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetInt64("$archived", int64(BoolToInt(archived)))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) IsChannelValid(id Snowflake, channelType int, name string, description string, parentID Snowflake) (bool, error) {
+	if channelType != ChannelTypeText && channelType != ChannelTypeVoice && channelType != ChannelTypeCategory {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("invalid channel type %d", channelType))
+	}
+
+	if name == "" || len(name) > 100 {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("channel name '%s' must be between 1 and 100 characters long", name))
+	}
+
+	if len(description) > 1024 {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("channel description must be at most 1024 characters long"))
+	}
+
+	if parentID == 0 {
+		return true, nil
+	}
+
+	if channelType == ChannelTypeCategory {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("categories cannot have a parent"))
+	}
+
+	if parentID == id {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("channel cannot be its own parent"))
+	}
+
+	parent, err := tx.GetChannel(parentID)
+	if err != nil {
+		return false, err
+	}
+
+	if parent.Type != ChannelTypeCategory {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("parent %d is not a category", parentID))
+	}
+
+	return true, nil
+}
+
+func (tx *Transaction) UpdateChannel(id Snowflake, name string, description string, position int, parentID Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE channels
+		SET
+			name = $name,
+			description = $description,
+			position = $position,
+			parent_id = $parent_id
+		WHERE id = $id;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(id))
+	stmt.SetText("$name", name)
+	stmt.SetText("$description", description)
+	stmt.SetInt64("$position", int64(position))
+
+	if parentID == 0 {
+		stmt.SetNull("$parent_id")
+	} else {
+		stmt.SetInt64("$parent_id", int64(parentID))
+	}
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) SetChannelPosition(id Snowflake, position int) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE channels SET position = $position WHERE id = $id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(id))
+	stmt.SetInt64("$position", int64(position))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) SetChannelOverwrite(channelID Snowflake, overwrite Overwrite) error {
+	tx.MarkAsWrite()
+
+	var query string
+	switch overwrite.Type {
+	case OverwriteTypeRole:
+		query = `INSERT OR REPLACE INTO channel_role_permissions(channel_id, role_id, allow, deny)
+			VALUES ($channel_id, $id, $allow, $deny);`
+	case OverwriteTypeUser:
+		query = `INSERT OR REPLACE INTO channel_user_permissions(channel_id, user_id, allow, deny)
+			VALUES ($channel_id, $id, $allow, $deny);`
+	default:
+		return NewError(ErrorCodeInvalidRequest, fmt.Errorf("invalid overwrite type %d", overwrite.Type))
+	}
+
+	stmt := tx.Prepare(query)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetInt64("$id", int64(overwrite.ID))
+	stmt.SetInt64("$allow", int64(overwrite.Allow))
+	stmt.SetInt64("$deny", int64(overwrite.Deny))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) DeleteChannelOverwrite(channelID Snowflake, overwriteType int, id Snowflake) error {
+	tx.MarkAsWrite()
+
+	var query string
+	switch overwriteType {
+	case OverwriteTypeRole:
+		query = `DELETE FROM channel_role_permissions WHERE channel_id = $channel_id AND role_id = $id;`
+	case OverwriteTypeUser:
+		query = `DELETE FROM channel_user_permissions WHERE channel_id = $channel_id AND user_id = $id;`
+	default:
+		return NewError(ErrorCodeInvalidRequest, fmt.Errorf("invalid overwrite type %d", overwriteType))
+	}
+
+	stmt := tx.Prepare(query)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetInt64("$id", int64(id))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) DeleteChannel(id Snowflake) error {
+	tx.MarkAsWrite()
+
+	// Threads go with their channel, rather than being orphaned like the children of a category
+	stmt := tx.Prepare(`DELETE FROM channels WHERE id = $id OR (parent_id = $id AND type = $thread);`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(id))
+	stmt.SetInt64("$thread", ChannelTypeThread)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) QueryRoles(id Snowflake) ([]Role, error) {
+	query := `SELECT
+			id,
+			name,
+			color,
+			position,
+			permissions,
+			hoisted,
+			mentionable
+		FROM
+			roles`
+	if id != 0 {
+		query += ` WHERE id = $id`
+	}
+	stmt := tx.Prepare(query + ` ORDER BY id;`)
+	defer tx.Finish(stmt)
+
+	if id != 0 {
+		stmt.SetInt64("$id", int64(id))
+	}
+
+	roles := []Role{}
+
+	for {
+		hasRow, stepErr := stmt.Step()
+		if stepErr != nil {
+			return nil, NewError(ErrorCodeInternalError, stepErr)
+		}
+		if !hasRow {
+			break
+		}
+		role := Role{
+			ID:          Snowflake(stmt.GetInt64("id")),
+			Name:        stmt.GetText("name"),
+			Color:       int(stmt.GetInt64("color")),
+			Permissions: int(stmt.GetInt64("permissions")),
+			Position:    int(stmt.GetInt64("position")),
+			Hoisted:     stmt.GetInt64("hoisted") != 0,
+			Mentionable: stmt.GetInt64("mentionable") != 0,
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+func (tx *Transaction) GetRole(id Snowflake) (Role, error) {
+	roles, err := tx.QueryRoles(id)
+	if err != nil {
+		return Role{}, err
+	}
+	if len(roles) == 0 {
+		return Role{}, NewError(ErrorCodeInvalidRequest, fmt.Errorf("role not found"))
+	}
+	return roles[0], nil
+}
+
+func (tx *Transaction) GetAllRoles() ([]Role, error) {
+	return tx.QueryRoles(0)
+}
+
+func (tx *Transaction) AddRole(name string, color int, position int, permissions int, hoisted bool, mentionable bool) (Snowflake, error) {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		INSERT INTO roles(id, name, color, position, permissions, hoisted, mentionable)
+		VALUES ($id, $name, $color, $position, $permissions, $hoisted, $mentionable);`,
+	)
+	defer tx.Finish(stmt)
+
+	roleID := snowflake.New()
+
+	stmt.SetInt64("$id", int64(roleID))
+	stmt.SetText("$name", name)
+	stmt.SetInt64("$color", int64(color))
+	stmt.SetInt64("$position", int64(position))
+	stmt.SetInt64("$permissions", int64(permissions))
+	stmt.SetBool("$hoisted", hoisted)
+	stmt.SetBool("$mentionable", mentionable)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return 0, NewError(ErrorCodeInternalError, err)
+	}
+
+	return roleID, nil
+}
+
+func (tx *Transaction) UpdateRole(id Snowflake, name string, color int, position int, permissions int, hoisted bool, mentionable bool) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE roles
+		SET
+			name = $name,
+			color = $color,
+			position = $position,
+			permissions = $permissions,
+			hoisted = $hoisted,
+			mentionable = $mentionable
+		WHERE id = $id;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(id))
+	stmt.SetText("$name", name)
+	stmt.SetInt64("$color", int64(color))
+	stmt.SetInt64("$position", int64(position))
+	stmt.SetInt64("$permissions", int64(permissions))
+	stmt.SetBool("$hoisted", hoisted)
+	stmt.SetBool("$mentionable", mentionable)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) DeleteRole(id Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`DELETE FROM roles WHERE id = $id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(id))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) AddRoleToUser(userID Snowflake, roleID Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`INSERT INTO user_roles(user_id, role_id) VALUES ($user_id, $role_id);`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetInt64("$role_id", int64(roleID))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) DeleteRoleFromUser(userID Snowflake, roleID Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`DELETE FROM user_roles WHERE user_id = $user_id AND role_id = $role_id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetInt64("$role_id", int64(roleID))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+func (tx *Transaction) QueryEmojis(id Snowflake) ([]Emoji, error) {
+	query := `SELECT
+			name,
+			id,
+			animated,
+			uploader_id
+		FROM
+			emojis`
+	if id != 0 {
+		query += ` WHERE id = $id`
+	}
+
+	stmt := tx.Prepare(query + ` ORDER BY name;`)
+	defer tx.Finish(stmt)
+
+	if id != 0 {
+		stmt.SetInt64("$id", int64(id))
+	}
+
+	emojis := []Emoji{}
+
+	for {
+		hasRow, stepErr := stmt.Step()
+		if stepErr != nil {
+			return nil, NewError(ErrorCodeInternalError, stepErr)
+		}
+		if !hasRow {
+			break
+		}
+		emoji := Emoji{
+			Name:       stmt.GetText("name"),
+			ID:         Snowflake(stmt.GetInt64("id")),
+			Animated:   stmt.GetBool("animated"),
+			UploaderID: Snowflake(stmt.GetInt64("uploader_id")),
+		}
+		emojis = append(emojis, emoji)
 	}
 
 	return emojis, nil
 }
 
-func (tx *Transaction) GetEmoji(id Snowflake) (Emoji, error) {
-	emjs, err := tx.QueryEmojis(id)
+func (tx *Transaction) GetEmoji(id Snowflake) (Emoji, error) {
+	emjs, err := tx.QueryEmojis(id)
+	if err != nil {
+		return Emoji{}, err
+	}
+	if len(emjs) == 0 {
+		return Emoji{}, NewError(ErrorCodeInvalidRequest, fmt.Errorf("emoji with ID %d not found", id))
+	}
+	return emjs[0], nil
+}
+
+func (tx *Transaction) GetAllEmojis() ([]Emoji, error) {
+	return tx.QueryEmojis(0)
+}
+
+func (tx *Transaction) IsEmojiNameTaken(name string, except Snowflake) (bool, error) {
+	stmt := tx.Prepare(`SELECT 1 FROM emojis WHERE name = $name AND id != $except;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetText("$name", name)
+	stmt.SetInt64("$except", int64(except))
+
+	hasRow, err := stmt.Step()
+	if err != nil {
+		return false, NewError(ErrorCodeInternalError, err)
+	}
+
+	return hasRow, nil
+}
+
+func (tx *Transaction) AddEmoji(id Snowflake, name string, animated bool, uploaderID Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		INSERT INTO emojis(id, name, animated, uploader_id, created_at)
+		VALUES ($id, $name, $animated, $uploader_id, $created_at);`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(id))
+	stmt.SetText("$name", name)
+	stmt.SetBool("$animated", animated)
+	stmt.SetInt64("$uploader_id", int64(uploaderID))
+	stmt.SetInt64("$created_at", time.Now().UnixMilli())
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) SetEmojiName(id Snowflake, name string) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE emojis SET name = $name WHERE id = $id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(id))
+	stmt.SetText("$name", name)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) DeleteEmoji(id Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`DELETE FROM emojis WHERE id = $id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(id))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) ValidateEmoji(emojiID Snowflake) bool {
+	if emoji.IsUnicodeEmojiID(int64(emojiID)) {
+		return true
+	} else {
+		if _, err := tx.GetEmoji(emojiID); err == nil {
+			return true
+		} else {
+			return false
+		}
+	}
+}
+
+func (tx *Transaction) UseToken(token string, ip string) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		UPDATE user_tokens
+		SET last_used_at = $last_used_at, ip = $ip
+		WHERE token = $token;`,
+	)
+	defer tx.Finish(stmt)
+
+	now := time.Now().UnixMilli()
+	stmt.SetText("$token", token)
+	stmt.SetInt64("$last_used_at", now)
+	stmt.SetText("$ip", ip)
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func isTokenExpired(lastUsedAt int64) bool {
+	if Config.TokenExpiry <= 0 {
+		return false
+	}
+	return time.Now().UnixMilli()-lastUsedAt > Config.TokenExpiry.Milliseconds()
+}
+
+func (tx *Transaction) Authenticate(token string, ip string) (Snowflake, error) {
+	stmt := tx.Prepare(`
+		SELECT
+			user_id,
+			last_used_at
+		FROM
+			user_tokens
+		WHERE
+			token = $token;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetText("$token", token)
+
+	hasRow, err := stmt.Step()
+	if err != nil {
+		return 0, NewError(ErrorCodeInternalError, err)
+	}
+
+	if !hasRow {
+		return 0, NewError(ErrorCodeInvalidToken, nil)
+	}
+
+	if isTokenExpired(stmt.GetInt64("last_used_at")) {
+		return 0, NewError(ErrorCodeInvalidToken, fmt.Errorf("token expired"))
+	}
+
+	userID := Snowflake(stmt.GetInt64("user_id"))
+
+	if err := tx.UseToken(token, ip); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (tx *Transaction) AddToken(userID Snowflake, ip string) (string, error) {
+	if err := tx.DeleteExpiredTokens(userID); err != nil {
+		return "", err
+	}
+
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		INSERT INTO user_tokens(user_id, token, created_at, last_used_at, ip)
+		VALUES ($user_id, $token, $created_at, $last_used_at, $ip);`,
+	)
+	defer tx.Finish(stmt)
+
+	token := GetRandom256()
+	now := time.Now().UnixMilli()
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetText("$token", token)
+	stmt.SetInt64("$created_at", now)
+	stmt.SetInt64("$last_used_at", now)
+
+	if ip != "" {
+		stmt.SetText("$ip", ip)
+	} else {
+		stmt.SetNull("$ip")
+	}
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return "", NewError(ErrorCodeInternalError, err)
+	}
+
+	return token, nil
+}
+
+func (tx *Transaction) DeleteToken(token string) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`DELETE FROM user_tokens WHERE token = $token;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetText("$token", token)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+// Deletes every token of a user except the given one (which may be empty)
+func (tx *Transaction) DeleteTokens(userID Snowflake, except string) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`DELETE FROM user_tokens WHERE user_id = $user_id AND token != $except;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetText("$except", except)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) DeleteExpiredTokens(userID Snowflake) error {
+	if Config.TokenExpiry <= 0 {
+		return nil
+	}
+
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`DELETE FROM user_tokens WHERE user_id = $user_id AND last_used_at < $cutoff;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetInt64("$cutoff", time.Now().Add(-Config.TokenExpiry).UnixMilli())
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+// Sessions are identified by a hash of their token so the token itself is never sent back out
+func GetSessionID(token string) string {
+	return HashSha256(token, "")[:32]
+}
+
+func (tx *Transaction) GetSessions(userID Snowflake) ([]Session, []string, error) {
+	stmt := tx.Prepare(`
+		SELECT
+			token,
+			created_at,
+			last_used_at,
+			ip
+		FROM
+			user_tokens
+		WHERE
+			user_id = $user_id
+		ORDER BY last_used_at DESC;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+
+	sessions := []Session{}
+	tokens := []string{}
+
+	for {
+		hasRow, stepErr := stmt.Step()
+		if stepErr != nil {
+			return nil, nil, NewError(ErrorCodeInternalError, stepErr)
+		}
+		if !hasRow {
+			break
+		}
+
+		lastUsedAt := stmt.GetInt64("last_used_at")
+		if isTokenExpired(lastUsedAt) {
+			continue
+		}
+
+		token := stmt.GetText("token")
+		sessions = append(sessions, Session{
+			ID:         GetSessionID(token),
+			CreatedAt:  int(stmt.GetInt64("created_at")),
+			LastUsedAt: int(lastUsedAt),
+			IP:         stmt.GetText("ip"),
+		})
+		tokens = append(tokens, token)
+	}
+
+	return sessions, tokens, nil
+}
+
+func (tx *Transaction) Login(username, password, ip string) (Snowflake, string, error) {
+	stmt := tx.Prepare(`
+		SELECT
+			id,
+			hash,
+			salt
+		FROM
+			users
+		WHERE
+			user_name = $user_name`,
+	)
+
+	stmt.SetText("$user_name", username)
+
+	hasRow, err := stmt.Step()
+	if err != nil {
+		tx.Finish(stmt)
+		return 0, "", NewError(ErrorCodeInternalError, err)
+	}
+
+	if !hasRow {
+		tx.Finish(stmt)
+		return 0, "", NewError(ErrorCodeInvalidCredentials, fmt.Errorf("user not found"))
+	}
+
+	salt := stmt.GetText("salt")
+	hash := stmt.GetText("hash")
+	userID := Snowflake(stmt.GetInt64("id"))
+	tx.Finish(stmt)
+
+	valid, rehash := VerifyPassword(password, hash, salt)
+	if !valid {
+		return 0, "", NewError(ErrorCodeInvalidCredentials, fmt.Errorf("invalid password"))
+	}
+
+	if err := tx.CheckBanned(userID, username, ip); err != nil {
+		return 0, "", err
+	}
+
+	// Upgrade legacy or outdated hashes now that we have the plaintext
+	if rehash {
+		if err := tx.SetUserPassword(userID, password); err != nil {
+			return 0, "", err
+		}
+	}
+
+	if token, err := tx.AddToken(userID, ip); err != nil {
+		return 0, "", err
+	} else {
+		return userID, token, nil
+	}
+}
+
+func (tx *Transaction) CheckUserPassword(userID Snowflake, password string) error {
+	stmt := tx.Prepare(`
+		SELECT
+			hash,
+			salt
+		FROM
+			users
+		WHERE
+			id = $id`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(userID))
+
+	hasRow, err := stmt.Step()
 	if err != nil {
-		return Emoji{}, err
+		return NewError(ErrorCodeInternalError, err)
 	}
-	if len(emjs) == 0 {
-		return Emoji{}, NewError(ErrorCodeInvalidRequest, fmt.Errorf("emoji with ID %d not found", id))
+
+	if !hasRow {
+		return NewError(ErrorCodeInvalidRequest, fmt.Errorf("user not found"))
 	}
-	return emjs[0], nil
+
+	if valid, _ := VerifyPassword(password, stmt.GetText("hash"), stmt.GetText("salt")); !valid {
+		return NewError(ErrorCodeInvalidCredentials, fmt.Errorf("invalid password"))
+	}
+
+	return nil
 }
 
-func (tx *Transaction) GetAllEmojis() ([]Emoji, error) {
-	return tx.QueryEmojis(0)
+func (tx *Transaction) SetUserPassword(userID Snowflake, password string) error {
+	if password == "" || len(password) > 1024 {
+		return NewError(ErrorCodeInvalidRequest, fmt.Errorf("password must be between 1 and 1024 characters long"))
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		UPDATE users
+		SET
+			hash = $hash,
+			salt = ''
+		WHERE id = $id;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(userID))
+	stmt.SetText("$hash", hash)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
 }
 
-func (tx *Transaction) ValidateEmoji(emojiID Snowflake) bool {
-	if emoji.IsUnicodeEmojiID(int64(emojiID)) {
-		return true
-	} else {
-		if _, err := tx.GetEmoji(emojiID); err == nil {
-			return true
-		} else {
-			return false
+func (tx *Transaction) QueryInviteCodes(userID Snowflake, code string) ([]InviteCode, error) {
+	query := `SELECT
+			user_id,
+			invite_code,
+			created_at,
+			expires_at,
+			uses,
+			max_uses,
+			invalidated
+		FROM
+			user_invite_codes`
+	if code != "" {
+		query += ` WHERE invite_code = $invite_code`
+	} else if userID != 0 {
+		query += ` WHERE user_id = $user_id`
+	}
+	stmt := tx.Prepare(query + ` ORDER BY created_at DESC;`)
+	defer tx.Finish(stmt)
+
+	if code != "" {
+		stmt.SetText("$invite_code", code)
+	} else if userID != 0 {
+		stmt.SetInt64("$user_id", int64(userID))
+	}
+
+	codes := []InviteCode{}
+
+	for {
+		hasRow, stepErr := stmt.Step()
+		if stepErr != nil {
+			return nil, NewError(ErrorCodeInternalError, stepErr)
+		}
+		if !hasRow {
+			break
 		}
+		codes = append(codes, InviteCode{
+			Code:        stmt.GetText("invite_code"),
+			UserID:      Snowflake(stmt.GetInt64("user_id")),
+			CreatedAt:   int(stmt.GetInt64("created_at")),
+			ExpiresAt:   int(stmt.GetInt64("expires_at")),
+			Uses:        int(stmt.GetInt64("uses")),
+			MaxUses:     int(stmt.GetInt64("max_uses")),
+			Invalidated: stmt.GetInt64("invalidated") != 0,
+		})
+	}
+
+	return codes, nil
+}
+
+func (tx *Transaction) GetInviteCode(code string) (InviteCode, error) {
+	if code == "" {
+		return InviteCode{}, NewError(ErrorCodeInvalidInviteCode, fmt.Errorf("invite code missing"))
+	}
+
+	codes, err := tx.QueryInviteCodes(0, code)
+	if err != nil {
+		return InviteCode{}, err
+	}
+	if len(codes) == 0 {
+		return InviteCode{}, NewError(ErrorCodeInvalidInviteCode, fmt.Errorf("invite code not found"))
 	}
+	return codes[0], nil
+}
+
+func (tx *Transaction) GetInviteCodes(userID Snowflake) ([]InviteCode, error) {
+	return tx.QueryInviteCodes(userID, "")
 }
 
-func (tx *Transaction) UseToken(token string) error {
+func (tx *Transaction) GetAllInviteCodes() ([]InviteCode, error) {
+	return tx.QueryInviteCodes(0, "")
+}
+
+func (tx *Transaction) AddInviteCode(userID Snowflake, expiresAt int, maxUses int) (InviteCode, error) {
 	tx.MarkAsWrite()
 	stmt := tx.Prepare(`
-		UPDATE user_tokens
-		SET last_used_at = $last_used_at
-		WHERE token = $token;`,
+		INSERT INTO user_invite_codes(user_id, invite_code, created_at, expires_at, max_uses)
+		VALUES ($user_id, $invite_code, $created_at, $expires_at, $max_uses);`,
 	)
 	defer tx.Finish(stmt)
 
-	now := time.Now().UnixMilli()
-	stmt.SetText("$token", token)
-	stmt.SetInt64("$last_used_at", now)
+	invite := InviteCode{
+		Code:      GetRandom128()[:12],
+		UserID:    userID,
+		CreatedAt: int(time.Now().UnixMilli()),
+		ExpiresAt: expiresAt,
+		MaxUses:   maxUses,
+	}
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetText("$invite_code", invite.Code)
+	stmt.SetInt64("$created_at", int64(invite.CreatedAt))
+
+	if expiresAt != 0 {
+		stmt.SetInt64("$expires_at", int64(expiresAt))
+	} else {
+		stmt.SetNull("$expires_at")
+	}
+
+	if maxUses != 0 {
+		stmt.SetInt64("$max_uses", int64(maxUses))
+	} else {
+		stmt.SetNull("$max_uses")
+	}
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return InviteCode{}, NewError(ErrorCodeInternalError, err)
+	}
+
+	return invite, nil
+}
+
+func (tx *Transaction) InvalidateInviteCode(code string) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE user_invite_codes SET invalidated = 1 WHERE invite_code = $invite_code;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetText("$invite_code", code)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+// Checks an invite code is still usable and counts the use, returns it so the inviter can be recorded
+func (tx *Transaction) UseInviteCode(code string) (InviteCode, error) {
+	invite, err := tx.GetInviteCode(code)
+	if err != nil {
+		return InviteCode{}, err
+	}
+
+	if invite.Invalidated {
+		return InviteCode{}, NewError(ErrorCodeInvalidInviteCode, fmt.Errorf("invite code invalidated"))
+	}
+
+	if invite.ExpiresAt != 0 && int64(invite.ExpiresAt) < time.Now().UnixMilli() {
+		return InviteCode{}, NewError(ErrorCodeInvalidInviteCode, fmt.Errorf("invite code expired"))
+	}
+
+	if invite.MaxUses != 0 && invite.Uses >= invite.MaxUses {
+		return InviteCode{}, NewError(ErrorCodeInvalidInviteCode, fmt.Errorf("invite code exhausted"))
+	}
+
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE user_invite_codes SET uses = uses + 1 WHERE invite_code = $invite_code;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetText("$invite_code", code)
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return InviteCode{}, NewError(ErrorCodeInternalError, err)
+	}
+
+	invite.Uses += 1
+	return invite, nil
+}
+
+func (tx *Transaction) SetUserInviter(userID Snowflake, invitedBy Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`UPDATE users SET invited_by = $invited_by WHERE id = $id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$id", int64(userID))
+
+	if invitedBy != 0 {
+		stmt.SetInt64("$invited_by", int64(invitedBy))
+	} else {
+		stmt.SetNull("$invited_by")
+	}
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) AddBan(userID Snowflake, userName, ip string, moderatorID Snowflake, reason string, expiresAt int) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		INSERT INTO user_bans(user_id, user_name, ip, moderator_id, reason, created_at, expires_at)
+		VALUES ($user_id, $user_name, $ip, $moderator_id, $reason, $created_at, $expires_at);`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetText("$user_name", userName)
+	stmt.SetInt64("$moderator_id", int64(moderatorID))
+	stmt.SetText("$reason", reason)
+	stmt.SetInt64("$created_at", time.Now().UnixMilli())
+
+	if ip != "" {
+		stmt.SetText("$ip", ip)
+	} else {
+		stmt.SetNull("$ip")
+	}
+
+	if expiresAt != 0 {
+		stmt.SetInt64("$expires_at", int64(expiresAt))
+	} else {
+		stmt.SetNull("$expires_at")
+	}
+
 	if _, err := tx.Execute(stmt); err != nil {
 		return NewError(ErrorCodeInternalError, err)
 	}
@@ -676,96 +1772,263 @@ func (tx *Transaction) UseToken(token string) error {
 	return nil
 }
 
-func (tx *Transaction) Authenticate(token string) (Snowflake, error) {
+// Fails with ErrorCodeBanned if any unexpired ban matches the user, their name or the IP
+func (tx *Transaction) CheckBanned(userID Snowflake, userName, ip string) error {
 	stmt := tx.Prepare(`
 		SELECT
-			user_id
+			reason
 		FROM
-			user_tokens
+			user_bans
 		WHERE
-			token = $token;`,
+			(user_id = $user_id OR user_name = $user_name OR ip = $ip)
+			AND (expires_at IS NULL OR expires_at > $now)
+		LIMIT 1;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetText("$user_name", userName)
+	stmt.SetInt64("$now", time.Now().UnixMilli())
+
+	if ip != "" {
+		stmt.SetText("$ip", ip)
+	} else {
+		stmt.SetNull("$ip")
+	}
+
+	hasRow, err := stmt.Step()
+	if err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	if hasRow {
+		return NewError(ErrorCodeBanned, fmt.Errorf("banned: %s", stmt.GetText("reason")))
+	}
+
+	return nil
+}
+
+func (tx *Transaction) SetUserSilence(userID, moderatorID Snowflake, reason string, expiresAt int) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		INSERT OR REPLACE INTO user_silences(user_id, moderator_id, reason, created_at, expires_at)
+		VALUES ($user_id, $moderator_id, $reason, $created_at, $expires_at);`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetInt64("$moderator_id", int64(moderatorID))
+	stmt.SetText("$reason", reason)
+	stmt.SetInt64("$created_at", time.Now().UnixMilli())
+	stmt.SetInt64("$expires_at", int64(expiresAt))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+func (tx *Transaction) DeleteUserSilence(userID Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`DELETE FROM user_silences WHERE user_id = $user_id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+// Returns when the user's silence expires, 0 if they are not silenced
+func (tx *Transaction) GetUserSilence(userID Snowflake) (int, error) {
+	stmt := tx.Prepare(`
+		SELECT
+			expires_at
+		FROM
+			user_silences
+		WHERE
+			user_id = $user_id AND expires_at > $now;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetInt64("$now", time.Now().UnixMilli())
+
+	hasRow, err := stmt.Step()
+	if err != nil {
+		return 0, NewError(ErrorCodeInternalError, err)
+	}
+
+	if !hasRow {
+		return 0, nil
+	}
+
+	return int(stmt.GetInt64("expires_at")), nil
+}
+
+func (tx *Transaction) GetAllUserSilences() (map[Snowflake]int, error) {
+	stmt := tx.Prepare(`
+		SELECT
+			user_id,
+			expires_at
+		FROM
+			user_silences
+		WHERE
+			expires_at > $now;`,
 	)
 	defer tx.Finish(stmt)
 
-	stmt.SetText("$token", token)
-
-	hasRow, err := stmt.Step()
-	if err != nil {
-		return 0, NewError(ErrorCodeInternalError, err)
-	}
+	stmt.SetInt64("$now", time.Now().UnixMilli())
 
-	if !hasRow {
-		return 0, NewError(ErrorCodeInvalidToken, nil)
-	}
+	silences := make(map[Snowflake]int)
 
-	if err := tx.UseToken(token); err != nil {
-		return 0, err
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		silences[Snowflake(stmt.GetInt64("user_id"))] = int(stmt.GetInt64("expires_at"))
 	}
 
-	userID := Snowflake(stmt.GetInt64("user_id"))
-	return userID, nil
+	return silences, nil
 }
 
-func (tx *Transaction) AddToken(userID Snowflake) (string, error) {
+// Records a privileged action, before and after are marshaled to JSON (nil is stored as NULL)
+func (tx *Transaction) AddAuditLogEntry(actorID, targetID Snowflake, action int, before, after any, reason string) error {
 	tx.MarkAsWrite()
 	stmt := tx.Prepare(`
-		INSERT INTO user_tokens(user_id, token, created_at, last_used_at)
-		VALUES ($user_id, $token, $created_at, $last_used_at);`,
+		INSERT INTO audit_log(id, actor_id, target_id, action, before, after, reason, created_at)
+		VALUES ($id, $actor_id, $target_id, $action, $before, $after, $reason, $created_at);`,
 	)
 	defer tx.Finish(stmt)
 
-	token := GetRandom256()
-	now := time.Now().UnixMilli()
+	stmt.SetInt64("$id", int64(snowflake.New()))
+	stmt.SetInt64("$actor_id", int64(actorID))
+	stmt.SetInt64("$action", int64(action))
+	stmt.SetText("$reason", reason)
+	stmt.SetInt64("$created_at", time.Now().UnixMilli())
 
-	stmt.SetInt64("$user_id", int64(userID))
-	stmt.SetText("$token", token)
-	stmt.SetInt64("$created_at", now)
-	stmt.SetInt64("$last_used_at", now)
+	if targetID != 0 {
+		stmt.SetInt64("$target_id", int64(targetID))
+	} else {
+		stmt.SetNull("$target_id")
+	}
+
+	for param, value := range map[string]any{"$before": before, "$after": after} {
+		if isNilValue(value) {
+			stmt.SetNull(param)
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return NewError(ErrorCodeInternalError, err)
+		}
+		stmt.SetText(param, string(data))
+	}
 
 	if _, err := tx.Execute(stmt); err != nil {
-		return "", NewError(ErrorCodeInternalError, err)
+		return NewError(ErrorCodeInternalError, err)
 	}
 
-	return token, nil
+	return nil
 }
 
-func (tx *Transaction) Login(username, password string) (Snowflake, string, error) {
-	stmt := tx.Prepare(`
-		SELECT
+// Also catches typed nils like a nil slice, which would otherwise be stored as "null"
+func isNilValue(value any) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Returns entries newest first, zero filters (and a negative action) match everything
+func (tx *Transaction) GetAuditLog(before Snowflake, limit int, actorID, targetID Snowflake, action int) ([]AuditLogEntry, error) {
+	query := `SELECT
 			id,
-			hash,
-			salt
+			actor_id,
+			target_id,
+			action,
+			before,
+			after,
+			reason,
+			created_at
 		FROM
-			users
+			audit_log
 		WHERE
-			user_name = $user_name`,
-	)
-	defer tx.Finish(stmt)
-
-	stmt.SetText("$user_name", username)
+			1 = 1`
 
-	hasRow, err := stmt.Step()
-	if err != nil {
-		return 0, "", NewError(ErrorCodeInternalError, err)
+	if before != 0 {
+		query += ` AND id < $before`
 	}
-
-	if !hasRow {
-		return 0, "", NewError(ErrorCodeInvalidCredentials, fmt.Errorf("user not found"))
+	if actorID != 0 {
+		query += ` AND actor_id = $actor_id`
+	}
+	if targetID != 0 {
+		query += ` AND target_id = $target_id`
+	}
+	if action >= 0 {
+		query += ` AND action = $action`
 	}
 
-	salt := stmt.GetText("salt")
-	hash := stmt.GetText("hash")
-	userID := Snowflake(stmt.GetInt64("id"))
+	stmt := tx.Prepare(query + ` ORDER BY id DESC LIMIT $limit;`)
+	defer tx.Finish(stmt)
 
-	if hash != HashSha256(password, salt) {
-		return 0, "", NewError(ErrorCodeInvalidCredentials, fmt.Errorf("invalid password"))
+	if before != 0 {
+		stmt.SetInt64("$before", int64(before))
 	}
+	if actorID != 0 {
+		stmt.SetInt64("$actor_id", int64(actorID))
+	}
+	if targetID != 0 {
+		stmt.SetInt64("$target_id", int64(targetID))
+	}
+	if action >= 0 {
+		stmt.SetInt64("$action", int64(action))
+	}
+	stmt.SetInt64("$limit", int64(limit))
 
-	if token, err := tx.AddToken(userID); err != nil {
-		return 0, "", err
-	} else {
-		return userID, token, nil
+	entries := []AuditLogEntry{}
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+
+		entry := AuditLogEntry{
+			ID:        Snowflake(stmt.GetInt64("id")),
+			ActorID:   Snowflake(stmt.GetInt64("actor_id")),
+			TargetID:  Snowflake(stmt.GetInt64("target_id")),
+			Action:    int(stmt.GetInt64("action")),
+			Reason:    stmt.GetText("reason"),
+			Timestamp: int(stmt.GetInt64("created_at")),
+		}
+		if data := stmt.GetText("before"); data != "" {
+			entry.Before = json.RawMessage(data)
+		}
+		if data := stmt.GetText("after"); data != "" {
+			entry.After = json.RawMessage(data)
+		}
+
+		entries = append(entries, entry)
 	}
+
+	return entries, nil
 }
 
 func (tx *Transaction) IsUsernameValid(username string) (bool, error) {
@@ -798,24 +2061,40 @@ func (tx *Transaction) IsUsernameValid(username string) (bool, error) {
 	return true, nil
 }
 
-func (tx *Transaction) Register(username, password, email, inviteCode string) (User, string, error) {
+func (tx *Transaction) Register(username, password, email, inviteCode string, invitedBy Snowflake, ip string) (User, string, error) {
 	tx.MarkAsWrite()
 
-	salt := GetRandom128()
-	hash := HashSha256(password, salt)
+	if password == "" || len(password) > 1024 {
+		return User{}, "", NewError(ErrorCodeInvalidRequest, fmt.Errorf("password must be between 1 and 1024 characters long"))
+	}
+
+	if err := tx.CheckBanned(0, username, ip); err != nil {
+		return User{}, "", err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return User{}, "", NewError(ErrorCodeInternalError, err)
+	}
 
-	userID, err := tx.AddUser(username, hash, salt, inviteCode, email)
+	userID, err := tx.AddUser(username, hash, "", inviteCode, email)
 	if err != nil {
 		return User{}, "", err
 	}
 
+	if invitedBy != 0 {
+		if err := tx.SetUserInviter(userID, invitedBy); err != nil {
+			return User{}, "", err
+		}
+	}
+
 	displayName := cases.Title(language.English, cases.NoLower).String(username)
 	err = tx.SetUserProfile(userID, displayName, "", "", ProfileColorDefault, AvatarModifiedDefault)
 	if err != nil {
 		return User{}, "", NewError(ErrorCodeInternalError, fmt.Errorf("failed to set user profile: %w", err))
 	}
 
-	token, err := tx.AddToken(userID)
+	token, err := tx.AddToken(userID, ip)
 	if err != nil {
 		return User{}, "", err
 	}
@@ -954,100 +2233,346 @@ func (tx *Transaction) GetSettings() (Settings, error) {
 		CaptchaSecretKey:   stmt.GetText("captcha_secret_key"),
 	}
 
-	return settings, nil
+	return settings, nil
+}
+
+func (tx *Transaction) IsSettingsValid(settings Settings) (bool, error) {
+	if settings.SiteName == "" || len(settings.SiteName) > 100 {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("site name '%s' must be between 1 and 100 characters long", settings.SiteName))
+	}
+
+	if len(settings.LoginMessage) > 2000 {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("login message must be at most 2000 characters long"))
+	}
+
+	if settings.DefaultPermissions&^PermissionAll != 0 || settings.DefaultPermissions < 0 {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("invalid default permissions %d", settings.DefaultPermissions))
+	}
+
+	if settings.DefaultPermissions&PermissionAdministrator != 0 {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("default permissions cannot include administrator"))
+	}
+
+	if (settings.UsesCaptcha || settings.UsesLoginCaptcha) && (settings.CaptchaSiteKey == "" || settings.CaptchaSecretKey == "") {
+		return false, NewError(ErrorCodeInvalidRequest, fmt.Errorf("captcha requires both a site key and a secret key"))
+	}
+
+	return true, nil
+}
+
+func (tx *Transaction) SetSettings(settings Settings) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		UPDATE settings
+		SET
+			site_name = $site_name,
+			login_message = $login_message,
+			default_permissions = $default_permissions,
+			uses_email = $uses_email,
+			uses_invite_code = $uses_invite_code,
+			uses_captcha = $uses_captcha,
+			uses_login_captcha = $uses_login_captcha,
+			captcha_site_key = $captcha_site_key,
+			captcha_secret_key = $captcha_secret_key
+		WHERE id = 0;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetText("$site_name", settings.SiteName)
+	stmt.SetText("$login_message", settings.LoginMessage)
+	stmt.SetInt64("$default_permissions", int64(settings.DefaultPermissions))
+	stmt.SetInt64("$uses_email", int64(BoolToInt(settings.UsesEmail)))
+	stmt.SetInt64("$uses_invite_code", int64(BoolToInt(settings.UsesInviteCodes)))
+	stmt.SetInt64("$uses_captcha", int64(BoolToInt(settings.UsesCaptcha)))
+	stmt.SetInt64("$uses_login_captcha", int64(BoolToInt(settings.UsesLoginCaptcha)))
+	stmt.SetText("$captcha_site_key", settings.CaptchaSiteKey)
+	stmt.SetText("$captcha_secret_key", settings.CaptchaSecretKey)
+
+	_, err := tx.Execute(stmt)
+	if err != nil {
+		return NewError(ErrorCodeInternalError, err)
+	}
+
+	return nil
+}
+
+//go:embed sql/message_query.sql
+var message_query_string string
+
+// TODO: Maybe split into multiple queries.
+func (tx *Transaction) GetMessagesByAnchor(channelID Snowflake, anchorID Snowflake, limit int, before bool) ([]Message, error) {
+	// Start with the base query from the embedded SQL file
+	baseQuery := strings.TrimSuffix(message_query_string, ";")
+
+	var finalQuery string
+	var params []int64
+
+	// Build the WHERE clause based on the anchorID and the 'before' flag
+	if anchorID != 0 {
+		if before {
+			finalQuery = baseQuery + `
+				WHERE m.channel_id = ? AND m.id < ?
+				ORDER BY m.id DESC
+				LIMIT ?;`
+			params = append(params, int64(channelID), int64(anchorID), int64(limit))
+		} else {
+			finalQuery = baseQuery + `
+				WHERE m.channel_id = ? AND m.id > ?
+				ORDER BY m.id ASC
+				LIMIT ?;`
+			params = append(params, int64(channelID), int64(anchorID), int64(limit))
+		}
+	} else {
+		// If no anchorID is provided, fetch the most recent messages
+		finalQuery = baseQuery + `
+			WHERE m.channel_id = ?
+			ORDER BY m.id DESC
+			LIMIT ?;`
+		params = append(params, int64(channelID), int64(limit))
+	}
+
+	stmt := tx.Prepare(finalQuery)
+	defer tx.Finish(stmt)
+
+	for i, param := range params {
+		stmt.BindInt64(i+1, param)
+	}
+
+	messages, err := tx.QueryMessages(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	// If fetching messages after the anchor, they were ordered ascending; reverse to maintain consistency
+	if anchorID == 0 || before {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return messages, nil
+}
+
+func (tx *Transaction) GetPinnedMessages(channelID Snowflake, before Snowflake, limit int) ([]Message, error) {
+	baseQuery := strings.TrimSuffix(message_query_string, ";")
+
+	var finalQuery string
+	var params []int64
+
+	if before != 0 {
+		finalQuery = baseQuery + `
+			WHERE m.channel_id = ? AND m.pinned != 0 AND m.id < ?
+			ORDER BY m.id DESC
+			LIMIT ?;`
+		params = append(params, int64(channelID), int64(before), int64(limit))
+	} else {
+		finalQuery = baseQuery + `
+			WHERE m.channel_id = ? AND m.pinned != 0
+			ORDER BY m.id DESC
+			LIMIT ?;`
+		params = append(params, int64(channelID), int64(limit))
+	}
+
+	stmt := tx.Prepare(finalQuery)
+	defer tx.Finish(stmt)
+
+	for i, param := range params {
+		stmt.BindInt64(i+1, param)
+	}
+
+	return tx.QueryMessages(stmt)
+}
+
+type MessageSearch struct {
+	Match         string      // FTS5 query (must be well formed), empty matches everything
+	ChannelIDs    []Snowflake // Channels to search, required
+	AuthorID      Snowflake
+	MentionsID    Snowflake
+	HasAttachment bool
+	HasEmbed      bool
+	HasLink       bool
+	Pinned        *bool
+	BeforeTime    int // UnixMilli, exclusive
+	AfterTime     int // UnixMilli, inclusive
+	Before        Snowflake
+	Limit         int
+}
+
+// Returns matching messages newest first
+func (tx *Transaction) SearchMessages(search MessageSearch) ([]Message, error) {
+	if len(search.ChannelIDs) == 0 {
+		return []Message{}, nil
+	}
+
+	baseQuery := strings.TrimSuffix(message_query_string, ";")
+
+	conditions := []string{}
+	params := []any{}
+
+	placeholders := make([]string, len(search.ChannelIDs))
+	for i, id := range search.ChannelIDs {
+		placeholders[i] = "?"
+		params = append(params, int64(id))
+	}
+	conditions = append(conditions, "m.channel_id IN ("+strings.Join(placeholders, ", ")+")")
+
+	if search.Match != "" {
+		conditions = append(conditions, "m.id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)")
+		params = append(params, search.Match)
+	}
+	if search.AuthorID != 0 {
+		conditions = append(conditions, "m.author_id = ?")
+		params = append(params, int64(search.AuthorID))
+	}
+	if search.MentionsID != 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM message_user_mentions su WHERE su.message_id = m.id AND su.user_id = ?)")
+		params = append(params, int64(search.MentionsID))
+	}
+	if search.HasAttachment {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM attachments sa WHERE sa.message_id = m.id)")
+	}
+	if search.HasEmbed {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM embeds se WHERE se.message_id = m.id)")
+	}
+	if search.HasLink {
+		conditions = append(conditions, "(m.content LIKE '%http://%' OR m.content LIKE '%https://%')")
+	}
+	if search.Pinned != nil {
+		if *search.Pinned {
+			conditions = append(conditions, "m.pinned != 0")
+		} else {
+			conditions = append(conditions, "m.pinned = 0")
+		}
+	}
+	if search.BeforeTime != 0 {
+		conditions = append(conditions, "m.timestamp < ?")
+		params = append(params, int64(search.BeforeTime))
+	}
+	if search.AfterTime != 0 {
+		conditions = append(conditions, "m.timestamp >= ?")
+		params = append(params, int64(search.AfterTime))
+	}
+	if search.Before != 0 {
+		conditions = append(conditions, "m.id < ?")
+		params = append(params, int64(search.Before))
+	}
+
+	finalQuery := baseQuery + `
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY m.id DESC
+		LIMIT ?;`
+	params = append(params, int64(search.Limit))
+
+	stmt := tx.Prepare(finalQuery)
+	defer tx.Finish(stmt)
+
+	for i, param := range params {
+		switch v := param.(type) {
+		case int64:
+			stmt.BindInt64(i+1, v)
+		case string:
+			stmt.BindText(i+1, v)
+		}
+	}
+
+	return tx.QueryMessages(stmt)
 }
 
-func (tx *Transaction) SetSettings(settings Settings) error {
+// Only moves forward, so a stale ack from another connection can't mark read messages as unread
+func (tx *Transaction) SetReadState(userID Snowflake, channelID Snowflake, messageID Snowflake) error {
 	tx.MarkAsWrite()
 	stmt := tx.Prepare(`
-		UPDATE settings
-		SET
-			site_name = $site_name,
-			login_message = $login_message,
-			default_permissions = $default_permissions,
-			uses_email = $uses_email,
-			uses_invite_code = $uses_invite_code,
-			uses_captcha = $uses_captcha,
-			uses_login_captcha = $uses_login_captcha,
-			captcha_site_key = $captcha_site_key,
-			captcha_secret_key = $captcha_secret_key
-		WHERE id = 0;`,
+		INSERT INTO read_states(user_id, channel_id, last_message_id)
+		VALUES ($user_id, $channel_id, $last_message_id)
+		ON CONFLICT(user_id, channel_id) DO UPDATE SET
+			last_message_id = MAX(last_message_id, excluded.last_message_id);`,
 	)
 	defer tx.Finish(stmt)
 
-	stmt.SetText("$site_name", settings.SiteName)
-	stmt.SetText("$login_message", settings.LoginMessage)
-	stmt.SetInt64("$default_permissions", int64(settings.DefaultPermissions))
-	stmt.SetInt64("$uses_email", int64(BoolToInt(settings.UsesEmail)))
-	stmt.SetInt64("$uses_invite_code", int64(BoolToInt(settings.UsesInviteCodes)))
-	stmt.SetInt64("$uses_captcha", int64(BoolToInt(settings.UsesCaptcha)))
-	stmt.SetInt64("$uses_login_captcha", int64(BoolToInt(settings.UsesLoginCaptcha)))
-	stmt.SetText("$captcha_site_key", settings.CaptchaSiteKey)
-	stmt.SetText("$captcha_secret_key", settings.CaptchaSecretKey)
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetInt64("$last_message_id", int64(messageID))
 
-	_, err := tx.Execute(stmt)
-	if err != nil {
+	if _, err := tx.Execute(stmt); err != nil {
 		return NewError(ErrorCodeInternalError, err)
 	}
 
 	return nil
 }
 
-//go:embed sql/message_query.sql
-var message_query_string string
+// Only this many of the newest unread messages are checked for mentions, so the count saturates
+const ReadStateScanLimit = 1000
+
+// Read states of the given channels, mentions count direct, role and @everyone mentions
+// The caller is expected to pass only channels the user can view
+func (tx *Transaction) GetReadStates(userID Snowflake, channelIDs []Snowflake) ([]ReadState, error) {
+	idsJSON, _ := json.Marshal(channelIDs)
+
+	stmt := tx.Prepare(`SELECT
+			c.id AS channel_id,
+			COALESCE(rs.last_message_id, 0) AS last_read_id,
+			(SELECT MAX(m.id) FROM messages m WHERE m.channel_id = c.id) AS last_message_id,
+			(
+				SELECT COUNT(*)
+				FROM messages m
+				WHERE m.id IN (
+						SELECT u.id FROM messages u
+						WHERE u.channel_id = c.id AND u.id > COALESCE(rs.last_message_id, 0)
+						ORDER BY u.id DESC
+						LIMIT $scan_limit
+					)
+					AND (m.author_id IS NULL OR m.author_id != $user_id)
+					AND (
+						m.mentions_everyone != 0
+						OR EXISTS (
+							SELECT 1 FROM message_user_mentions mu
+							WHERE mu.message_id = m.id AND mu.user_id = $user_id
+						)
+						OR EXISTS (
+							SELECT 1 FROM message_role_mentions mr
+							JOIN user_roles ur ON ur.role_id = mr.role_id
+							WHERE mr.message_id = m.id AND ur.user_id = $user_id
+						)
+					)
+			) AS mention_count
+		FROM
+			channels c
+		LEFT JOIN
+			read_states rs ON rs.channel_id = c.id AND rs.user_id = $user_id
+		WHERE
+			c.type != $category
+			AND c.id IN (SELECT value FROM json_each($channel_ids));`,
+	)
+	defer tx.Finish(stmt)
 
-// TODO: Maybe split into multiple queries.
-func (tx *Transaction) GetMessagesByAnchor(channelID Snowflake, anchorID Snowflake, limit int, before bool) ([]Message, error) {
-	// Start with the base query from the embedded SQL file
-	baseQuery := strings.TrimSuffix(message_query_string, ";")
+	stmt.SetInt64("$user_id", int64(userID))
+	stmt.SetInt64("$category", ChannelTypeCategory)
+	stmt.SetInt64("$scan_limit", ReadStateScanLimit)
+	stmt.SetText("$channel_ids", string(idsJSON))
 
-	var finalQuery string
-	var params []int64
+	states := []ReadState{}
 
-	// Build the WHERE clause based on the anchorID and the 'before' flag
-	if anchorID != 0 {
-		if before {
-			finalQuery = baseQuery + `
-				WHERE m.channel_id = ? AND m.id < ?
-				ORDER BY m.id DESC
-				LIMIT ?;`
-			params = append(params, int64(channelID), int64(anchorID), int64(limit))
-		} else {
-			finalQuery = baseQuery + `
-				WHERE m.channel_id = ? AND m.id > ?
-				ORDER BY m.id ASC
-				LIMIT ?;`
-			params = append(params, int64(channelID), int64(anchorID), int64(limit))
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
 		}
-	} else {
-		// If no anchorID is provided, fetch the most recent messages
-		finalQuery = baseQuery + `
-			WHERE m.channel_id = ?
-			ORDER BY m.id DESC
-			LIMIT ?;`
-		params = append(params, int64(channelID), int64(limit))
-	}
-
-	stmt := tx.Prepare(finalQuery)
-	defer tx.Finish(stmt)
-
-	for i, param := range params {
-		stmt.BindInt64(i+1, param)
-	}
-
-	messages, err := tx.QueryMessages(stmt)
-	if err != nil {
-		return nil, err
-	}
 
-	// If fetching messages after the anchor, they were ordered ascending; reverse to maintain consistency
-	if anchorID == 0 || before {
-		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-			messages[i], messages[j] = messages[j], messages[i]
+		state := ReadState{
+			ChannelID:     Snowflake(stmt.GetInt64("channel_id")),
+			LastReadID:    Snowflake(stmt.GetInt64("last_read_id")),
+			LastMessageID: Snowflake(stmt.GetInt64("last_message_id")),
+			MentionCount:  int(stmt.GetInt64("mention_count")),
 		}
+		state.Unread = state.LastMessageID > state.LastReadID
+
+		states = append(states, state)
 	}
 
-	return messages, nil
+	return states, nil
 }
 
 func (tx *Transaction) GetMessages(ids []Snowflake, required bool) ([]Message, error) {
@@ -1100,15 +2625,16 @@ func (tx *Transaction) QueryMessages(stmt *sqlite.Stmt) ([]Message, error) {
 
 		// Extract basic message fields
 		message := Message{
-			ID:              Snowflake(stmt.GetInt64("id")),
-			Type:            int(stmt.GetInt64("type")),
-			ChannelID:       Snowflake(stmt.GetInt64("channel_id")),
-			Timestamp:       int(stmt.GetInt64("timestamp")),
-			Pinned:          stmt.GetInt64("pinned") != 0,
-			AuthorID:        Snowflake(stmt.GetInt64("author_id")),
-			ReferenceID:     Snowflake(stmt.GetInt64("reference_id")),
-			Content:         stmt.GetText("content"),
-			EditedTimestamp: int(stmt.GetInt64("edited_timestamp")),
+			ID:               Snowflake(stmt.GetInt64("id")),
+			Type:             int(stmt.GetInt64("type")),
+			ChannelID:        Snowflake(stmt.GetInt64("channel_id")),
+			Timestamp:        int(stmt.GetInt64("timestamp")),
+			Pinned:           stmt.GetInt64("pinned") != 0,
+			AuthorID:         Snowflake(stmt.GetInt64("author_id")),
+			ReferenceID:      Snowflake(stmt.GetInt64("reference_id")),
+			Content:          stmt.GetText("content"),
+			EditedTimestamp:  int(stmt.GetInt64("edited_timestamp")),
+			MentionsEveryone: stmt.GetInt64("mentions_everyone") != 0,
 		}
 
 		// Parse Attachments JSON
@@ -1147,6 +2673,19 @@ func (tx *Transaction) QueryMessages(stmt *sqlite.Stmt) ([]Message, error) {
 			return nil, fmt.Errorf("failed to unmarshal mentioned_channels: %w", err)
 		}
 
+		// Parse Emojis JSON
+		emojisJSON := stmt.GetText("emojis")
+		if err := json.Unmarshal([]byte(emojisJSON), &message.Emojis); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal emojis: %w", err)
+		}
+
+		// Parse Thread JSON
+		if threadJSON := stmt.GetText("thread"); threadJSON != "" {
+			if err := json.Unmarshal([]byte(threadJSON), &message.Thread); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal thread: %w", err)
+			}
+		}
+
 		messages = append(messages, message)
 	}
 
@@ -1204,6 +2743,45 @@ func (tx *Transaction) AddAttachment(messageID Snowflake, attachment *Attachment
 	return nil
 }
 
+// Maps every attachment ID to its message ID
+func (tx *Transaction) GetAttachmentMessages() (map[Snowflake]Snowflake, error) {
+	stmt := tx.Prepare(`SELECT id, message_id FROM attachments;`)
+	defer tx.Finish(stmt)
+
+	attachments := map[Snowflake]Snowflake{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		attachments[Snowflake(stmt.GetInt64("id"))] = Snowflake(stmt.GetInt64("message_id"))
+	}
+
+	return attachments, nil
+}
+
+func (tx *Transaction) GetChannelMessageCounts() (map[Snowflake]int, error) {
+	stmt := tx.Prepare(`SELECT channel_id, COUNT(*) AS count FROM messages GROUP BY channel_id;`)
+	defer tx.Finish(stmt)
+
+	counts := map[Snowflake]int{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		counts[Snowflake(stmt.GetInt64("channel_id"))] = int(stmt.GetInt64("count"))
+	}
+
+	return counts, nil
+}
+
 func (tx *Transaction) GetAttachment(messageID Snowflake, attachmentID Snowflake, filename string) (Attachment, error) {
 	stmt := tx.Prepare(`
 		SELECT
@@ -1455,7 +3033,7 @@ func (tx *Transaction) AddMessage(message *Message) error {
 	}
 
 	tx.MarkAsWrite()
-	messages_stmt := tx.Prepare("INSERT OR REPLACE INTO messages (id, type, channel_id, timestamp, author_id, reference_id, content) VALUES ($id, $type, $channel_id, $timestamp, $author_id, $reference_id, $content);")
+	messages_stmt := tx.Prepare("INSERT OR REPLACE INTO messages (id, type, channel_id, timestamp, author_id, reference_id, content, mentions_everyone) VALUES ($id, $type, $channel_id, $timestamp, $author_id, $reference_id, $content, $mentions_everyone);")
 
 	messages_stmt.SetInt64("$id", int64(message.ID))
 	messages_stmt.SetInt64("$type", int64(message.Type))
@@ -1463,6 +3041,7 @@ func (tx *Transaction) AddMessage(message *Message) error {
 	messages_stmt.SetInt64("$timestamp", int64(message.Timestamp))
 	messages_stmt.SetInt64("$author_id", int64(message.AuthorID))
 	messages_stmt.SetText("$content", message.Content)
+	messages_stmt.SetBool("$mentions_everyone", message.MentionsEveryone)
 
 	if message.ReferenceID != 0 {
 		messages_stmt.SetInt64("$reference_id", int64(message.ReferenceID))
@@ -1479,6 +3058,10 @@ func (tx *Transaction) AddMessage(message *Message) error {
 
 	tx.SetMessageMentions(message.ID, message.MentionedUsers, message.MentionedRoles, message.MentionedChannels)
 
+	if err := tx.SetMessageEmojis(message.ID, message.Emojis); err != nil {
+		return err
+	}
+
 	for _, embed := range message.Embeds {
 		if err := tx.AddEmbed(message.ID, &embed); err != nil {
 			return err
@@ -1494,32 +3077,37 @@ func (tx *Transaction) AddMessage(message *Message) error {
 	return nil
 }
 
-func (tx *Transaction) SetMessage(id Snowflake, content string, mentionedUsers []Snowflake, mentionedRoles []Snowflake, mentionedChannels []Snowflake, deletedEmbeds []Snowflake) error {
+func (tx *Transaction) SetMessage(id Snowflake, content string, mentionsEveryone bool, mentionedUsers []Snowflake, mentionedRoles []Snowflake, mentionedChannels []Snowflake, emojis []Emoji, deletedEmbeds []Snowflake) error {
 	tx.MarkAsWrite()
 	if err := tx.DeleteEmbeds(deletedEmbeds); err != nil {
 		return err
 	}
 
-	if err := tx.SetMessageContent(id, content); err != nil {
+	if err := tx.SetMessageContent(id, content, mentionsEveryone); err != nil {
 		return err
 	}
 
 	if err := tx.SetMessageMentions(id, mentionedUsers, mentionedRoles, mentionedChannels); err != nil {
 		return err
 	}
+
+	if err := tx.SetMessageEmojis(id, emojis); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (tx *Transaction) SetMessageContent(id Snowflake, content string) error {
+func (tx *Transaction) SetMessageContent(id Snowflake, content string, mentionsEveryone bool) error {
 	tx.MarkAsWrite()
 	stmt := tx.Prepare(`
 		UPDATE messages
-		SET content = $content, edited_timestamp = $edited_timestamp
+		SET content = $content, mentions_everyone = $mentions_everyone, edited_timestamp = $edited_timestamp
 		WHERE id = $id;`,
 	)
 	defer tx.Finish(stmt)
 
 	stmt.SetText("$content", content)
+	stmt.SetBool("$mentions_everyone", mentionsEveryone)
 	stmt.SetInt64("$edited_timestamp", time.Now().UnixMilli())
 	stmt.SetInt64("$id", int64(id))
 
@@ -1530,6 +3118,25 @@ func (tx *Transaction) SetMessageContent(id Snowflake, content string) error {
 	return nil
 }
 
+func (tx *Transaction) SetMessagePinned(id Snowflake, pinned bool) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		UPDATE messages
+		SET pinned = $pinned
+		WHERE id = $id;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetBool("$pinned", pinned)
+	stmt.SetInt64("$id", int64(id))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, fmt.Errorf("failed to set message pinned: %w", err))
+	}
+
+	return nil
+}
+
 func (tx *Transaction) SetMessageMentions(id Snowflake, mentionedUsers []Snowflake, mentionedRoles []Snowflake, mentionedChannels []Snowflake) error {
 	tx.MarkAsWrite()
 	user_delete_stmt := tx.Prepare(`DELETE FROM message_user_mentions WHERE message_id = $message_id;`)
@@ -1595,6 +3202,47 @@ func (tx *Transaction) SetMessageMentions(id Snowflake, mentionedUsers []Snowfla
 	return nil
 }
 
+// Records the custom emoji used by a message, unknown IDs are skipped. Rows outlive the emoji so clients can fall back to the name
+func (tx *Transaction) SetMessageEmojis(id Snowflake, emojis []Emoji) error {
+	tx.MarkAsWrite()
+
+	keep := make([]int64, 0, len(emojis))
+	for _, emoji := range emojis {
+		keep = append(keep, int64(emoji.ID))
+	}
+	keepJSON, _ := json.Marshal(keep)
+
+	delete_stmt := tx.Prepare(`
+		DELETE FROM message_emojis
+		WHERE message_id = $message_id
+			AND emoji_id NOT IN (SELECT value FROM json_each($keep));`,
+	)
+	delete_stmt.SetInt64("$message_id", int64(id))
+	delete_stmt.SetText("$keep", string(keepJSON))
+	_, err := tx.Execute(delete_stmt)
+	tx.Finish(delete_stmt)
+	if err != nil {
+		return NewError(ErrorCodeInternalError, fmt.Errorf("failed to clear message emojis: %w", err))
+	}
+
+	insert_stmt := tx.Prepare(`
+		INSERT OR IGNORE INTO message_emojis (message_id, emoji_id, name, animated)
+		SELECT $message_id, id, name, animated FROM emojis WHERE id = $emoji_id;`,
+	)
+	for _, emoji := range emojis {
+		insert_stmt.SetInt64("$message_id", int64(id))
+		insert_stmt.SetInt64("$emoji_id", int64(emoji.ID))
+		_, err := tx.Execute(insert_stmt)
+		tx.Finish(insert_stmt)
+		if err != nil {
+			return NewError(ErrorCodeInternalError, fmt.Errorf("failed to add message emoji: %w", err))
+		}
+	}
+	tx.Finish(insert_stmt)
+
+	return nil
+}
+
 func (tx *Transaction) DeleteMessage(id Snowflake) error {
 	tx.MarkAsWrite()
 	stmt := tx.Prepare(`
@@ -1612,6 +3260,97 @@ func (tx *Transaction) DeleteMessage(id Snowflake) error {
 	return nil
 }
 
+// Returns which of the given messages exist in the channel
+func (tx *Transaction) FilterChannelMessages(channelID Snowflake, ids []Snowflake) ([]Snowflake, error) {
+	idsJSON, _ := json.Marshal(ids)
+
+	stmt := tx.Prepare(`
+		SELECT id FROM messages
+		WHERE channel_id = $channel_id
+			AND id IN (SELECT value FROM json_each($ids));`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$channel_id", int64(channelID))
+	stmt.SetText("$ids", string(idsJSON))
+
+	found := []Snowflake{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		found = append(found, Snowflake(stmt.GetInt64("id")))
+	}
+
+	return found, nil
+}
+
+// Newest first, authorID, after and before are ignored when 0
+func (tx *Transaction) GetChannelMessageIDs(channelID Snowflake, authorID Snowflake, after int, before int, limit int) ([]Snowflake, error) {
+	query := `SELECT id FROM messages WHERE channel_id = $channel_id`
+	if authorID != 0 {
+		query += ` AND author_id = $author_id`
+	}
+	if after != 0 {
+		query += ` AND timestamp >= $after`
+	}
+	if before != 0 {
+		query += ` AND timestamp < $before`
+	}
+
+	stmt := tx.Prepare(query + ` ORDER BY id DESC LIMIT $limit;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$channel_id", int64(channelID))
+	if authorID != 0 {
+		stmt.SetInt64("$author_id", int64(authorID))
+	}
+	if after != 0 {
+		stmt.SetInt64("$after", int64(after))
+	}
+	if before != 0 {
+		stmt.SetInt64("$before", int64(before))
+	}
+	stmt.SetInt64("$limit", int64(limit))
+
+	ids := []Snowflake{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		ids = append(ids, Snowflake(stmt.GetInt64("id")))
+	}
+
+	return ids, nil
+}
+
+func (tx *Transaction) DeleteMessages(ids []Snowflake) error {
+	tx.MarkAsWrite()
+	idsJSON, _ := json.Marshal(ids)
+
+	stmt := tx.Prepare(`
+		DELETE FROM messages
+		WHERE id IN (SELECT value FROM json_each($ids));`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetText("$ids", string(idsJSON))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, fmt.Errorf("failed to delete messages: %w", err))
+	}
+
+	return nil
+}
+
 func (tx *Transaction) AddReaction(messageID Snowflake, userID Snowflake, emojiID Snowflake) error {
 	tx.MarkAsWrite()
 	if !tx.ValidateEmoji(emojiID) {
@@ -1635,6 +3374,65 @@ func (tx *Transaction) AddReaction(messageID Snowflake, userID Snowflake, emojiI
 	return nil
 }
 
+// Maps each message with a reaction using the emoji to its channel
+func (tx *Transaction) GetReactionMessagesByEmoji(emojiID Snowflake) (map[Snowflake]Snowflake, error) {
+	stmt := tx.Prepare(`
+		SELECT DISTINCT r.message_id, m.channel_id
+		FROM reactions r
+		JOIN messages m ON m.id = r.message_id
+		WHERE r.emoji_id = $emoji_id;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$emoji_id", int64(emojiID))
+
+	messages := map[Snowflake]Snowflake{}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, NewError(ErrorCodeInternalError, err)
+		}
+		if !hasRow {
+			break
+		}
+		messages[Snowflake(stmt.GetInt64("message_id"))] = Snowflake(stmt.GetInt64("channel_id"))
+	}
+
+	return messages, nil
+}
+
+func (tx *Transaction) DeleteReactionsByEmoji(messageID Snowflake, emojiID Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`
+		DELETE FROM reactions
+		WHERE message_id = $message_id AND emoji_id = $emoji_id;`,
+	)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$message_id", int64(messageID))
+	stmt.SetInt64("$emoji_id", int64(emojiID))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, fmt.Errorf("failed to delete reactions: %w", err))
+	}
+
+	return nil
+}
+
+func (tx *Transaction) DeleteAllReactions(messageID Snowflake) error {
+	tx.MarkAsWrite()
+	stmt := tx.Prepare(`DELETE FROM reactions WHERE message_id = $message_id;`)
+	defer tx.Finish(stmt)
+
+	stmt.SetInt64("$message_id", int64(messageID))
+
+	if _, err := tx.Execute(stmt); err != nil {
+		return NewError(ErrorCodeInternalError, fmt.Errorf("failed to delete reactions: %w", err))
+	}
+
+	return nil
+}
+
 func (tx *Transaction) DeleteReaction(messageID Snowflake, userID Snowflake, emojiID Snowflake) error {
 	tx.MarkAsWrite()
 	if !tx.ValidateEmoji(emojiID) {
@@ -1803,6 +3601,26 @@ func (tx *Transaction) GetPermissionsByChannel(userID Snowflake, channelID Snowf
 
 	if channelID != 0 {
 		if channel, err := tx.GetChannel(channelID); err == nil {
+			// Threads follow the channel they branch from
+			if channel.Type == ChannelTypeThread {
+				if channel.ParentID == 0 {
+					return 0
+				}
+				return tx.GetPermissionsByChannel(userID, channel.ParentID)
+			}
+
+			// Membership is the only access check for private channels, even for administrators
+			if channel.IsPrivate() {
+				if !channel.HasRecipient(userID) {
+					return 0
+				}
+				allow = PermissionPrivateChannel
+				if silencedUntil, _ := tx.GetUserSilence(userID); silencedUntil != 0 {
+					allow &^= PermissionSilenced
+				}
+				return allow
+			}
+
 			for _, overwrite := range channel.Overwrites {
 				if overwrite.Type == OverwriteTypeRole {
 					for _, roleID := range user.Roles {
@@ -1828,5 +3646,9 @@ func (tx *Transaction) GetPermissionsByChannel(userID Snowflake, channelID Snowf
 		return PermissionAll
 	}
 
+	if silencedUntil, _ := tx.GetUserSilence(userID); silencedUntil != 0 {
+		permissions &^= PermissionSilenced
+	}
+
 	return permissions
 }
@@ -34,7 +34,37 @@ func tryExecuteStatement(stmt *sqlite.Stmt) bool {
 	return err == nil
 }
 
-func PopulateDatabase(ctx context.Context) {
+type SeedOptions struct {
+	Users    int
+	Channels int
+	// Messages per channel
+	Messages int
+	Seed     int64
+}
+
+func DefaultSeedOptions() SeedOptions {
+	return SeedOptions{
+		Users:    1000,
+		Channels: 8,
+		Messages: 200,
+		Seed:     101,
+	}
+}
+
+func (o SeedOptions) Validate() error {
+	if o.Users < 1 {
+		return fmt.Errorf("need at least 1 user")
+	}
+	if o.Channels < 1 {
+		return fmt.Errorf("need at least 1 channel")
+	}
+	if o.Messages < 0 {
+		return fmt.Errorf("message count must not be negative")
+	}
+	return nil
+}
+
+func PopulateDatabase(ctx context.Context, opts SeedOptions) {
 	// populate database with fake data
 
 	db, err := storage.OpenConnection(ctx)
@@ -49,18 +79,24 @@ func PopulateDatabase(ctx context.Context) {
 		DefaultPermissions: PermissionDefault,
 		UsesEmail:          false,
 		UsesInviteCodes:    false,
-		UsesCaptcha:        true,
+		UsesCaptcha:        false,
 		UsesLoginCaptcha:   false,
-		CaptchaSiteKey:     "052869f2-0556-4700-9b48-8bf6807003b6",
-		CaptchaSecretKey:   "ES_040e28547c354609b8d8c0e7a630f6d3",
 	}
 
+	// A populated database keeps its own settings and accounts
 	tx := storage.NewTransaction(db)
 	tx.Start()
-	err = tx.SetSettings(settings)
+	existing, err := tx.GetUserCount()
+	if err == nil && existing == 0 {
+		err = tx.SetSettings(settings)
+	}
 	tx.Commit(err)
+	if err != nil {
+		panic(err)
+	}
 
-	rng := rand.New(rand.NewSource(101))
+	rng := rand.New(rand.NewSource(opts.Seed))
+	fake.Seed(opts.Seed)
 
 	roles := [3]Snowflake{0, 0, 0}
 
@@ -79,22 +115,26 @@ func PopulateDatabase(ctx context.Context) {
 	}
 	tx.Commit(nil)
 
-	const userCount = 1000
-	users := [userCount]Snowflake{}
+	userCount := opts.Users
+	users := make([]Snowflake, userCount)
+
+	// Hashing is deliberately slow, so the fake users all share one unguessable password
+	hash, err := HashPassword(HashSha256(GetRandom256(), ""))
+	if err != nil {
+		panic(err)
+	}
 
 	tx.Start()
 	for i := 0; i < userCount; i++ {
-		var password = fake.SimplePassword()
-		var userName = fake.UserName() + GetRandom128()[:5]
+		// The index keeps names unique without breaking reproducibility
+		var userName = fmt.Sprintf("%s%d", fake.UserName(), i)
 
 		for len(userName) < 3 || len(userName) > 32 {
-			userName = fake.UserName() + GetRandom128()[:5]
+			userName = fmt.Sprintf("%s%d", fake.UserName(), i)
 		}
 
 		var displayName = fake.FullName()
 		var presence = rng.Int63n(3)
-		var salt = GetRandom128()
-		var hash = HashSha256(HashSha256(password, ""), salt)
 		var status = ""
 		if rng.Intn(5) == 0 {
 			status = fake.Sentence()
@@ -108,7 +148,7 @@ func PopulateDatabase(ctx context.Context) {
 			}
 		}
 
-		users[i], err = tx.AddUser(userName, hash, salt, "", "")
+		users[i], err = tx.AddUser(userName, hash, "", "", "")
 		if err != nil {
 			fmt.Printf("Failed to add user %d: %v\n", i, err.Error())
 			panic(err)
@@ -151,10 +191,12 @@ func PopulateDatabase(ctx context.Context) {
 
 	tx.Commit(nil)
 
-	createMainUser(db, roles[1])
+	if existing == 0 {
+		createMainUser(db, roles[1])
+	}
 
-	const channelCount = 8
-	channels := [channelCount]Snowflake{}
+	channelCount := opts.Channels
+	channels := make([]Snowflake, channelCount)
 
 	// Get all file in attachmentDir
 	attachmentDir := "../media/test"
@@ -197,16 +239,19 @@ func PopulateDatabase(ctx context.Context) {
 	tx.Commit(nil)
 
 	for i := 0; i < channelCount; i++ {
-		for k := 0; k < 200; k++ {
+		for k := 0; k < opts.Messages; k++ {
+			// Counted from the newest message, so they show up first
+			last := opts.Messages - 1 - k
+
 			url := ""
-			if 199-k < len(URLs) && i == 0 {
-				url = URLs[199-k]
+			if last < len(URLs) && i == 0 {
+				url = URLs[last]
 			}
 
-			id := createMessage(db, channels[i], users[:], roles[:], channels[:], url, rng)
+			id := createMessage(db, channels[i], users, roles[:], channels, url, rng)
 
-			if 199-k < len(attachments) && i == 0 {
-				idx := 199 - k
+			if last < len(attachments) && i == 0 {
+				idx := last
 				for _, path := range attachments[idx] {
 					createAttachment(db, id, path)
 				}
@@ -217,19 +262,26 @@ func PopulateDatabase(ctx context.Context) {
 
 func createMainUser(db *sqlite.Conn, role Snowflake) {
 	var password = "password"
-	var salt = GetRandom128()
-	var hash = HashSha256(HashSha256(password, ""), salt)
+	hash, err := HashPassword(HashSha256(password, ""))
+	if err != nil {
+		panic(err)
+	}
 
 	tx := storage.NewTransaction(db)
 	tx.Start()
 
-	id, _ := tx.AddUser("user", hash, salt, "", "")
+	id, _ := tx.AddUser("user", hash, "", "", "")
 	_ = tx.SetUserProfile(id, "User", "", "", ProfileColorDefault, AvatarModifiedDefault)
 	_ = tx.SetUserPresence(id, UserPresenceOnline)
 
 	tx.AddRoleToUser(id, role)
 
 	tx.Commit(nil)
+
+	fmt.Println("================================================================")
+	fmt.Println("  Created moderator account 'user' with the password 'password'")
+	fmt.Println("  This is for development only, never seed a public server")
+	fmt.Println("================================================================")
 }
 
 func createMessage(db *sqlite.Conn, channelID Snowflake, users []Snowflake, roles []Snowflake, channels []Snowflake, url string, rng *rand.Rand) Snowflake {
@@ -254,10 +306,11 @@ func createMessage(db *sqlite.Conn, channelID Snowflake, users []Snowflake, role
 	msg.Type = MessageTypeDefault
 	msg.Timestamp = int(time.Now().UnixMilli())
 
-	mentionedUsers, mentionedRoles, mentionedChannels, embeddableURLs := chat.ParseMessageContent(content)
+	mentionedUsers, mentionedRoles, mentionedChannels, emojis, embeddableURLs := chat.ParseMessageContent(content)
 	msg.MentionedUsers = mentionedUsers
 	msg.MentionedRoles = mentionedRoles
 	msg.MentionedChannels = mentionedChannels
+	msg.Emojis = emojis
 	msg.EmbeddableURLs = embeddableURLs
 
 	tx := storage.NewTransaction(db)